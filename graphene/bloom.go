@@ -0,0 +1,66 @@
+package graphene
+
+import (
+	"math"
+)
+
+// BloomFilter is a Bloom filter of source symbols.
+type BloomFilter struct {
+	Bits   []uint64
+	Hashes int
+}
+
+// NewBloomFilter returns an empty BloomFilter with the given number of bits,
+// rounded up to a multiple of 64, and of hash functions.
+func NewBloomFilter(bits int, hashes int) BloomFilter {
+	return BloomFilter{
+		Bits:   make([]uint64, (max(bits, 1)+63)/64),
+		Hashes: max(hashes, 1),
+	}
+}
+
+// bloomHash returns the two halves of the 64-bit hash of source symbol t,
+// the SplitMix64 finalizer of t, from which the hash functions are derived
+// by double hashing.
+func bloomHash(t HashType) (uint32, uint32) {
+	z := uint64(t) + 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return uint32(z), uint32(z>>32) | 1
+}
+
+// Add inserts source symbol t into f.
+func (f BloomFilter) Add(t HashType) {
+	h1, h2 := bloomHash(t)
+	m := uint64(len(f.Bits)) * 64
+	for i := 0; i < f.Hashes; i++ {
+		bit := (uint64(h1) + uint64(i)*uint64(h2)) % m
+		f.Bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// Contains reports whether t may have been inserted into f. It is always
+// true if t was inserted.
+func (f BloomFilter) Contains(t HashType) bool {
+	h1, h2 := bloomHash(t)
+	m := uint64(len(f.Bits)) * 64
+	for i := 0; i < f.Hashes; i++ {
+		bit := (uint64(h1) + uint64(i)*uint64(h2)) % m
+		if f.Bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomBits returns the number of bits and of hash functions of the
+// smallest Bloom filter of n source symbols with false positive rate fpr.
+func bloomBits(n int, fpr float64) (int, int) {
+	if n == 0 || fpr >= 1 {
+		return 0, 0
+	}
+	bits := math.Ceil(-float64(n) * math.Log(fpr) / (math.Ln2 * math.Ln2))
+	hashes := math.Round(bits / float64(n) * math.Ln2)
+	return int(bits), max(int(hashes), 1)
+}
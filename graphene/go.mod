@@ -0,0 +1,9 @@
+module github.com/ygina/subset-reconciliation/graphene
+
+go 1.21
+
+require github.com/yangl1996/riblt v0.0.0
+
+require github.com/dchest/siphash v1.2.3 // indirect
+
+replace github.com/yangl1996/riblt => ../riblt
@@ -0,0 +1,134 @@
+// Package graphene implements Graphene set reconciliation: a Bloom filter
+// followed by an IBLT, which here is a riblt.Sketch, for comparison with
+// QuACK and Rateless IBLTs.
+//
+// The sender holds a set S of n source symbols, and the receiver holds a set
+// R of m source symbols that contains S, as in QuACK, where R is usually
+// much larger. The sender sends a Bloom filter of S, and a Sketch of S. The
+// receiver passes R through the filter, which keeps S and about fpr*(m-n)
+// false positives, and decodes the Sketch of the source symbols that pass
+// minus the Sketch of S. The Sketch then only needs to cover the false
+// positives, not all of R \ S, so the two together are much smaller than a
+// Sketch alone when m is much larger than n. Optimize picks the false
+// positive rate that minimizes their total size.
+//
+// Like riblt.Sketch, Graphene here only supports subset reconciliation: if
+// S is not a subset of R, Decode fails.
+package graphene
+
+import (
+	"math"
+
+	"github.com/yangl1996/riblt"
+)
+
+type HashType = riblt.HashType
+const HashTypeSize = riblt.HashTypeSize
+
+// CellBytes is the typical size of a coded symbol of a Sketch on the wire:
+// the hash, and the count as a varint, which is small after subtraction.
+const CellBytes = 6
+
+// Params are the parameters of a Message.
+type Params struct {
+	// FPR is the false positive rate of the Bloom filter. 1 means no
+	// filter, i.e., a plain Sketch.
+	FPR         float64
+	BloomBits   int
+	BloomHashes int
+	// Cells is the number of coded symbols of the Sketch.
+	Cells int
+}
+
+// Size returns the approximate size of a Message with parameters p in bytes.
+func (p Params) Size() int {
+	return (p.BloomBits+7)/8 + p.Cells*CellBytes
+}
+
+// cellsFor returns the number of coded symbols for a Sketch to decode a
+// difference of x source symbols with probability at least 99%. The mean is
+// about 1.35x, and the margin covers the spread of small differences, as
+// measured with riblt.Decoder.
+func cellsFor(x float64) int {
+	return int(math.Ceil(1.35*x + 8*math.Sqrt(x) + 4))
+}
+
+// Optimize returns the Params that minimize the Size of the Message, for a
+// sender with n source symbols and a receiver with m.
+func Optimize(n, m int) Params {
+	// source symbols of the receiver that are not the sender's, which pass
+	// the filter with probability fpr
+	extra := float64(max(m-n, 0))
+	best := Params{FPR: 1, Cells: cellsFor(extra)}
+	if n == 0 {
+		// A filter of no source symbols has no bits, which NewBloomFilter
+		// would round up to a word that Size does not count.
+		return best
+	}
+	// log-spaced false positive rates from 1 down to about 2^-40
+	for i := 1; i <= 400; i++ {
+		fpr := math.Exp(-float64(i) * 0.07)
+		bits, hashes := bloomBits(n, fpr)
+		// the false positives exceed their mean by 3 standard deviations
+		// with probability about 0.1%
+		a := fpr * extra
+		p := Params{
+			FPR:         fpr,
+			BloomBits:   bits,
+			BloomHashes: hashes,
+			Cells:       cellsFor(a + 3*math.Sqrt(a)),
+		}
+		if p.Size() < best.Size() {
+			best = p
+		}
+	}
+	return best
+}
+
+// Message is what the sender sends.
+type Message struct {
+	// Filter is the Bloom filter of the set of the sender, or has no Bits
+	// if Params.FPR is 1.
+	Filter BloomFilter
+	Sketch riblt.Sketch
+}
+
+// Encode returns the Message for the set of the sender with parameters p.
+func Encode(set []HashType, p Params) Message {
+	msg := Message{Sketch: make(riblt.Sketch, p.Cells)}
+	if p.FPR < 1 {
+		msg.Filter = NewBloomFilter(p.BloomBits, p.BloomHashes)
+	}
+	for _, t := range set {
+		if len(msg.Filter.Bits) != 0 {
+			msg.Filter.Add(t)
+		}
+		msg.Sketch.AddSymbol(t)
+	}
+	return msg
+}
+
+// Decode tries to compute R \ S, where S is the set of the sender of msg,
+// and local is R. It fails when the Sketch is too small for the false
+// positives of the filter, in which case the sender may retry with a larger
+// Sketch, or when S is not a subset of R.
+func (msg Message) Decode(local []HashType) (missing []HashType, succ bool) {
+	z := make(riblt.Sketch, len(msg.Sketch))
+	for _, t := range local {
+		if len(msg.Filter.Bits) == 0 || msg.Filter.Contains(t) {
+			z.AddSymbol(t)
+		} else {
+			// certainly not in S
+			missing = append(missing, t)
+		}
+	}
+	// The false positives are Z \ S, and S \ Z, which is S \ R, is empty
+	// if S is a subset of R. Otherwise, riblt.Sketch.Decode fails once it
+	// finds a source symbol of S \ R, and so does Decode.
+	z.Subtract(msg.Sketch)
+	fp, rev, succ := z.Decode()
+	if !succ || len(rev) != 0 {
+		return nil, false
+	}
+	return append(missing, fp...), true
+}
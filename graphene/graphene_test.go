@@ -0,0 +1,155 @@
+package graphene
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// newSets returns a set S of n source symbols for the sender, and a set R
+// of m source symbols for the receiver that contains all but d of S, drawn
+// from rng, and S \ R and R \ S, sorted.
+func newSets(rng *rand.Rand, n, m, d int) (s, r, onlyS, onlyR []HashType) {
+	seen := map[HashType]bool{}
+	for len(seen) < m+d {
+		x := rng.Uint32()
+		if seen[x] {
+			continue
+		}
+		seen[x] = true
+		i := len(seen) - 1
+		switch {
+		case i < d:
+			s = append(s, x)
+			onlyS = append(onlyS, x)
+		case i < n:
+			s = append(s, x)
+			r = append(r, x)
+		default:
+			r = append(r, x)
+			onlyR = append(onlyR, x)
+		}
+	}
+	slices.Sort(onlyS)
+	slices.Sort(onlyR)
+	return
+}
+
+func TestBloomFilter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	bits, hashes := bloomBits(1000, 0.01)
+	f := NewBloomFilter(bits, hashes)
+	for i := 0; i < 1000; i++ {
+		f.Add(HashType(i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.Contains(HashType(i)) {
+			t.Fatalf("false negative %d", i)
+		}
+	}
+	fp := 0
+	for i := 0; i < 100000; i++ {
+		if f.Contains(rng.Uint32() | 1<<31) {
+			fp += 1
+		}
+	}
+	if fp < 700 || fp > 1300 {
+		t.Errorf("false positive rate is %v, expected 0.01", float64(fp)/100000)
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	// equal sets need no filter, and neither does an empty one
+	if p := Optimize(1000, 1000); p.FPR != 1 {
+		t.Errorf("filter with FPR %v for equal sets", p.FPR)
+	}
+	if p := Optimize(0, 1000); p.FPR != 1 || len(Encode(nil, p).Filter.Bits) != 0 {
+		t.Errorf("filter with FPR %v for an empty set", p.FPR)
+	}
+	// the larger the receiver, the lower the false positive rate
+	prev := Optimize(1000, 2000)
+	for _, m := range []int{10000, 100000, 1000000} {
+		p := Optimize(1000, m)
+		plain := cellsFor(float64(m-1000)) * CellBytes
+		if p.FPR >= prev.FPR || p.Size() >= plain {
+			t.Errorf("m=%d: FPR %v, size %d, plain Sketch %d", m, p.FPR, p.Size(), plain)
+		}
+		prev = p
+	}
+}
+
+func TestSubset(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for _, tc := range []struct{ n, m int }{
+		{0, 0}, {100, 100}, {0, 100}, {100, 1000}, {1000, 10000}, {1000, 100000},
+	} {
+		p := Optimize(tc.n, tc.m)
+		succ := 0
+		for trial := 0; trial < 20; trial++ {
+			s, r, _, onlyR := newSets(rng, tc.n, tc.m, 0)
+			missing, ok := Encode(s, p).Decode(r)
+			slices.Sort(missing)
+			if ok {
+				if !slices.Equal(missing, onlyR) {
+					t.Fatalf("%+v: wrong difference", tc)
+				}
+				succ += 1
+			}
+		}
+		if succ < 19 {
+			t.Errorf("%+v: decoded %d of 20", tc, succ)
+		}
+	}
+}
+
+func TestNotSubset(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	n, m := 1000, 10000
+	p := Optimize(n, m)
+	for _, d := range []int{1, 10, 1000} {
+		for trial := 0; trial < 20; trial++ {
+			s, r, _, _ := newSets(rng, n, m, d)
+			if missing, ok := Encode(s, p).Decode(r); ok {
+				t.Errorf("d=%d: decoded %d missing source symbols", d, len(missing))
+			}
+		}
+	}
+}
+
+// BenchmarkGraphene reports the size of the Message in units of source
+// symbols per difference, comparable to the symbols/diff of the other
+// packages, for a sender with 1000 source symbols and a receiver with all
+// of them and d more, and how often it decodes.
+func BenchmarkGraphene(bc *testing.B) {
+	cases := []struct {
+		name string
+		size int
+	}{
+		{"d=10", 10},
+		{"d=100", 100},
+		{"d=1000", 1000},
+		{"d=10000", 10000},
+		{"d=100000", 100000},
+	}
+	for _, tc := range cases {
+		bc.Run(tc.name, func(b *testing.B) {
+			n, d := 1000, tc.size
+			p := Optimize(n, n+d)
+			rng := rand.New(rand.NewSource(1))
+			nsucc := 0
+			b.ResetTimer()
+			b.StopTimer()
+			for iter := 0; iter < b.N; iter++ {
+				s, r, _, _ := newSets(rng, n, n+d, 0)
+				b.StartTimer()
+				_, ok := Encode(s, p).Decode(r)
+				b.StopTimer()
+				if ok {
+					nsucc += 1
+				}
+			}
+			b.ReportMetric(float64(p.Size())/float64(HashTypeSize)/float64(d), "symbols/diff")
+			b.ReportMetric(float64(nsucc)/float64(b.N), "succ")
+		})
+	}
+}
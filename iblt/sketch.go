@@ -0,0 +1,154 @@
+// Package iblt implements traditional Invertible Bloom Lookup Tables (IBLTs)
+// with a fixed number of cells and k hash functions, as a baseline for
+// comparing against Rateless IBLTs and QuACK.
+//
+// The API mirrors riblt.Sketch. The cells are the same as riblt coded
+// symbols, so the two are directly comparable in the number of cells needed
+// to decode a set difference. Unlike a Rateless IBLT, an IBLT must be sized
+// for the set difference in advance: when the difference is too large,
+// decoding fails and the sketch is of no use.
+package iblt
+
+type HashType = uint32
+const HashTypeSize int64 = 4
+
+// DefaultK is the number of hash functions NewSketch uses, which minimizes
+// the number of cells needed for large set differences.
+const DefaultK = 3
+
+// Cell is a cell of an IBLT: the XOR of the source symbols hashed to it, and
+// their number, counting removed source symbols as -1.
+type Cell struct {
+	Hash  HashType
+	Count int64
+}
+
+// Sketch is an IBLT of a set of source symbols. Each source symbol is hashed
+// to K cells, one in each of K equal parts of Cells, so that the K cells are
+// distinct.
+type Sketch struct {
+	Cells []Cell
+	K     int
+}
+
+// NewSketch returns the Sketch of the empty set with m cells and DefaultK
+// hash functions.
+func NewSketch(m int) Sketch {
+	return NewSketchWithK(m, DefaultK)
+}
+
+// NewSketchWithK returns the Sketch of the empty set with m cells and k hash
+// functions. m must be at least k.
+func NewSketchWithK(m int, k int) Sketch {
+	if k < 1 || m < k {
+		panic("sketch must have at least k >= 1 cells")
+	}
+	return Sketch{Cells: make([]Cell, m), K: k}
+}
+
+// cell returns the cell that the i-th hash function maps source symbol t to.
+func (s Sketch) cell(t HashType, i int) int {
+	lo := i * len(s.Cells) / s.K
+	hi := (i + 1) * len(s.Cells) / s.K
+	// SplitMix64 finalizer of t and i
+	z := uint64(t)<<8 | uint64(i)
+	z += 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return lo + int(z%uint64(hi-lo))
+}
+
+// apply adds source symbol t to all its cells with the given count.
+func (s Sketch) apply(t HashType, count int64) {
+	for i := 0; i < s.K; i++ {
+		c := &s.Cells[s.cell(t, i)]
+		c.Hash ^= t
+		c.Count += count
+	}
+}
+
+// AddSymbol inserts source symbol t to the set of which s is a sketch.
+func (s Sketch) AddSymbol(t HashType) {
+	s.apply(t, 1)
+}
+
+// RemoveSymbol deletes source symbol t from the set of which s is a sketch.
+func (s Sketch) RemoveSymbol(t HashType) {
+	s.apply(t, -1)
+}
+
+// Subtract subtracts s2 from s by modifying s in place. s and s2 must have
+// the same number of cells and hash functions. If s is a sketch of set S and
+// s2 is a sketch of set S2, then the result is a sketch of the symmetric
+// difference between S and S2.
+func (s Sketch) Subtract(s2 Sketch) {
+	if len(s.Cells) != len(s2.Cells) || s.K != s2.K {
+		panic("subtracting sketches of different sizes")
+	}
+
+	for i := range s.Cells {
+		s.Cells[i].Count -= s2.Cells[i].Count
+		s.Cells[i].Hash ^= s2.Cells[i].Hash
+	}
+}
+
+// pure returns true if and only if cell i of s holds a single source symbol,
+// i.e., its count is 1 or -1, and its hash is a source symbol hashed to it.
+func (s Sketch) pure(i int) bool {
+	c := s.Cells[i]
+	if c.Count != 1 && c.Count != -1 {
+		return false
+	}
+	for j := 0; j < s.K; j++ {
+		if s.cell(c.Hash, j) == i {
+			return true
+		}
+	}
+	return false
+}
+
+// Decode tries to decode s, where s can be one of the following
+//  1. A sketch of set S.
+//  2. Content of s after calling s.Subtract(s2), where s is a sketch of set
+//     S, and s2 is a sketch of set S2.
+//
+// When successful, indicated by succ being true, fwd contains all source
+// symbols in S in case 1, or S \ S2 in case 2 (\ is the set subtraction
+// operation). rev is empty in case 1, or S2 \ S in case 2. Decode does not
+// modify s.
+func (s Sketch) Decode() (fwd []HashType, rev []HashType, succ bool) {
+	p := Sketch{Cells: append([]Cell(nil), s.Cells...), K: s.K}
+	var pending []int
+	for i := range p.Cells {
+		if p.pure(i) {
+			pending = append(pending, i)
+		}
+	}
+	for len(pending) > 0 {
+		i := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		// the cell may have changed since it was found pure
+		if !p.pure(i) {
+			continue
+		}
+		c := p.Cells[i]
+		if c.Count == 1 {
+			fwd = append(fwd, c.Hash)
+		} else {
+			rev = append(rev, c.Hash)
+		}
+		p.apply(c.Hash, -c.Count)
+		for j := 0; j < p.K; j++ {
+			if k := p.cell(c.Hash, j); p.pure(k) {
+				pending = append(pending, k)
+			}
+		}
+	}
+	for _, c := range p.Cells {
+		if c.Count != 0 || c.Hash != 0 {
+			return fwd, rev, false
+		}
+	}
+	return fwd, rev, true
+}
@@ -0,0 +1,227 @@
+package iblt
+
+import (
+	"testing"
+)
+
+func BenchmarkIBLTEncode(b *testing.B) {
+	benches := []struct {
+		name string
+		size int
+	}{
+		{"m=10", 10},
+		{"m=20", 20},
+		{"m=40", 40},
+		{"m=80", 80},
+		{"m=160", 160},
+		{"m=320", 320},
+		{"m=1000", 1000},
+		{"m=10000", 10000},
+		{"m=100000", 100000},
+		{"m=1000000", 1000000},
+		{"m=10000000", 10000000},
+	}
+	for _, bench := range benches {
+		s := NewSketch(bench.size)
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(HashTypeSize)
+			for i := 0; i < b.N; i++ {
+				s.AddSymbol(HashType(i))
+			}
+		})
+	}
+}
+
+func oneIBLTDecode(b *testing.B, d int, n int, log []HashType, num_cells int, time bool) bool {
+	slocal := NewSketch(num_cells)
+	sremote := NewSketch(num_cells)
+	for i := 0; i < d + n; i++ {
+		slocal.AddSymbol(log[i])
+	}
+	for i := 0; i < n; i++ {
+		sremote.AddSymbol(log[i])
+	}
+
+	if time {
+		b.StartTimer()
+	}
+	slocal.Subtract(sremote)
+	_, _, succ := slocal.Decode()
+	if time {
+		b.StopTimer()
+	}
+	return succ
+}
+
+// ibltTrials is the number of set differences BenchmarkIBLTDecode decodes
+// for each number of cells, and ibltTarget is the fraction of them that must
+// decode.
+const (
+	ibltTrials = 100
+	ibltTarget = 0.99
+)
+
+// ibltSuccessRate returns the fraction of ibltTrials set differences, each
+// between d+n and n fresh source symbols, that num_cells cells decode.
+func ibltSuccessRate(b *testing.B, d int, n int, log []HashType, nextId *HashType, num_cells int) float64 {
+	nsucc := 0
+	for trial := 0; trial < ibltTrials; trial++ {
+		for i := 0; i < d + n; i++ {
+			*nextId += 1
+			log[i] = *nextId
+		}
+		if oneIBLTDecode(b, d, n, log, num_cells, false) {
+			nsucc += 1
+		}
+	}
+	return float64(nsucc) / ibltTrials
+}
+
+// BenchmarkIBLTDecode reports the number of cells per difference an IBLT
+// needs, comparable to the symbols/diff of BenchmarkRIBLTDecode in package
+// riblt. Whether a given IBLT decodes depends on the set difference, and not
+// monotonically on the number of cells, so the benchmark sweeps the number
+// of cells up from d in steps of 5% of d, decodes ibltTrials set differences
+// for each, and reports the smallest number that decodes at least ibltTarget
+// of them. succ@2x and succ@4x are the fractions of set differences an IBLT
+// with 2 and 4 cells per difference decodes. Only the decoding of the
+// smallest IBLT is timed.
+func BenchmarkIBLTDecode(bc *testing.B) {
+	cases := []struct {
+		name string
+		size int
+	}{
+		{"d=10", 10},
+		{"d=20", 20},
+		{"d=40", 40},
+		{"d=80", 80},
+		{"d=160", 160},
+		{"d=320", 320},
+		{"d=1000", 1000},
+		{"d=10000", 10000},
+	}
+	for _, tc := range cases {
+		bc.Run(tc.name, func(b *testing.B) {
+			d := tc.size
+			n := tc.size
+			b.SetBytes(HashTypeSize * int64(tc.size))
+			log := make([]HashType, d + n)
+			step := max(1, d / 20)
+			ncw := 0
+			succ2x := 0.0
+			succ4x := 0.0
+			var nextId HashType
+			b.ResetTimer()
+			b.StopTimer()
+			for iter := 0; iter < b.N; iter++ {
+				m := d
+				for ibltSuccessRate(b, d, n, log, &nextId, m) < ibltTarget {
+					m += step
+				}
+				oneIBLTDecode(b, d, n, log, m, true)
+				ncw += m
+				succ2x += ibltSuccessRate(b, d, n, log, &nextId, 2 * d)
+				succ4x += ibltSuccessRate(b, d, n, log, &nextId, 4 * d)
+			}
+			b.ReportMetric(float64(ncw)/float64(b.N * d), "symbols/diff")
+			b.ReportMetric(succ2x/float64(b.N), "succ@2x")
+			b.ReportMetric(succ4x/float64(b.N), "succ@4x")
+		})
+	}
+}
+
+func TestCells(t *testing.T) {
+	for _, k := range []int{1, 3, 4} {
+		s := NewSketchWithK(10, k)
+		for x := HashType(0); x < 1000; x++ {
+			seen := map[int]bool{}
+			for i := 0; i < k; i++ {
+				c := s.cell(x, i)
+				if c < i * 10 / k || c >= (i + 1) * 10 / k || seen[c] {
+					t.Fatalf("k=%d: hash function %d maps %d to cell %d", k, i, x, c)
+				}
+				seen[c] = true
+			}
+		}
+	}
+}
+
+func TestSubtractAndDecode(t *testing.T) {
+	s1 := NewSketch(30)
+	s2 := NewSketch(30)
+	for _, x := range []HashType{1, 2, 3, 4, 5} {
+		s1.AddSymbol(x)
+	}
+	for _, x := range []HashType{4, 5, 6} {
+		s2.AddSymbol(x)
+	}
+	s1.Subtract(s2)
+	fwd, rev, succ := s1.Decode()
+	if !succ || len(fwd) != 3 || len(rev) != 1 || rev[0] != 6 {
+		t.Errorf("decoded %v %v %t", fwd, rev, succ)
+	}
+	s1.RemoveSymbol(1)
+	s1.RemoveSymbol(2)
+	s1.RemoveSymbol(3)
+	s1.AddSymbol(6)
+	for _, c := range s1.Cells {
+		if c != (Cell{}) {
+			t.Fatalf("sketch is not empty: %v", s1.Cells)
+		}
+	}
+}
+
+func TestDecodeFailure(t *testing.T) {
+	s := NewSketch(10)
+	for x := HashType(0); x < 100; x++ {
+		s.AddSymbol(x)
+	}
+	if fwd, rev, succ := s.Decode(); succ {
+		t.Errorf("decoded %d and %d symbols from 10 cells", len(fwd), len(rev))
+	}
+}
+
+func TestFixedEncodeAndDecode(t *testing.T) {
+	cases := []struct {
+		name string
+		size int
+	}{
+		{"d=10", 10},
+		{"d=20", 20},
+		{"d=40", 40},
+		{"d=100", 100},
+		{"d=1000", 1000},
+		{"d=10000", 10000},
+		{"d=50000", 50000},
+		{"d=100000", 100000},
+	}
+	for _, tc := range cases {
+		nlocal := tc.size
+		ncommon := tc.size
+		var nextId uint32
+		slocal := NewSketch(nlocal * 3)
+		sremote := NewSketch(nlocal * 3)
+		for i := 0; i < nlocal; i++ {
+			nextId += 1
+			slocal.AddSymbol(nextId)
+		}
+		for i := 0; i < ncommon; i++ {
+			nextId += 1
+			slocal.AddSymbol(nextId)
+			sremote.AddSymbol(nextId)
+		}
+
+		// Decode
+		slocal.Subtract(sremote)
+		fwd, rev, succ := slocal.Decode()
+		if !succ {
+			t.Errorf("(size=%d) failed to decode at all", tc.size)
+		}
+		if len(rev) != 0 {
+			t.Errorf("(size=%d) failed to detect subset", tc.size)
+		}
+		if len(fwd) != nlocal {
+			t.Errorf("(size=%d) missing symbols: %d local", tc.size, len(fwd))
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Package merkle implements anti-entropy set reconciliation with a Merkle
+// trie, as used by many replicated systems, for comparison with QuACK and
+// Rateless IBLTs.
+//
+// Each party arranges its set of source symbols in a trie with Levels levels,
+// where each level branches on the next Radix bits of the source symbols,
+// so a leaf is a single source symbol. The digest of an inner node is a hash
+// of the digests of its children. The parties exchange the digests of the
+// children of the nodes whose digests differ, descending one level per
+// message, until they reach the leaves, which takes Levels/2 round trips
+// regardless of the size of the difference.
+package merkle
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+type HashType = uint32
+const HashTypeSize int64 = 4
+
+const (
+	// Radix is the number of bits of a source symbol each level of the trie
+	// branches on.
+	Radix = 4
+	// Fanout is the number of children of an inner node.
+	Fanout = 1 << Radix
+	// Levels is the depth of the leaves of the trie.
+	Levels = 32 / Radix
+)
+
+// Node identifies a node of the trie: the node at depth Depth with Prefix,
+// the top Radix*Depth bits of the source symbols beneath it.
+type Node struct {
+	Depth  int
+	Prefix uint32
+}
+
+// child returns the c-th child of n.
+func (n Node) child(c int) Node {
+	return Node{n.Depth + 1, n.Prefix<<Radix | uint32(c)}
+}
+
+// Entry describes the children of a node of the trie of the sender: Mask
+// has bit c set if and only if the c-th child is not empty, and Digests has
+// the digests of the nonempty children, in order. The children of a node at
+// depth Levels-1 are leaves, whose digests are implied by the source symbols,
+// so Digests is empty.
+type Entry struct {
+	Parent  Node
+	Mask    uint16
+	Digests []uint64
+}
+
+// Message is a message of the protocol. An empty Message from the initiator
+// ends the protocol.
+type Message []Entry
+
+// Reconciler is one party of the protocol.
+type Reconciler struct {
+	// levels[k] maps the prefix of each nonempty node at depth k to its
+	// digest
+	levels    [Levels + 1]map[uint32]uint64
+	initiator bool
+	have      []HashType
+	need      []HashType
+}
+
+// leafDigest returns the digest of the leaf of source symbol t, the
+// SplitMix64 finalizer of t, which is never 0, the digest of empty nodes.
+func leafDigest(t HashType) uint64 {
+	z := uint64(t) + 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return (z ^ (z >> 31)) | 1
+}
+
+// NewReconciler returns a Reconciler for set. It does not modify set.
+func NewReconciler(set []HashType) *Reconciler {
+	r := &Reconciler{}
+	for k := range r.levels {
+		r.levels[k] = map[uint32]uint64{}
+	}
+	for _, t := range set {
+		r.levels[Levels][t] = leafDigest(t)
+	}
+	// hash the children of each node in order, bottom up
+	for k := Levels - 1; k >= 0; k-- {
+		parents := map[uint32]bool{}
+		for p := range r.levels[k+1] {
+			parents[p>>Radix] = true
+		}
+		for p := range parents {
+			h := fnv.New64a()
+			var buf [8]byte
+			for c := 0; c < Fanout; c++ {
+				binary.LittleEndian.PutUint64(buf[:], r.digest(Node{k, p}.child(c)))
+				h.Write(buf[:])
+			}
+			r.levels[k][p] = h.Sum64() | 1
+		}
+	}
+	return r
+}
+
+// digest returns the digest of node n, or 0 if it is empty.
+func (r *Reconciler) digest(n Node) uint64 {
+	return r.levels[n.Depth][n.Prefix]
+}
+
+// entry returns the Entry describing the children of n.
+func (r *Reconciler) entry(n Node) Entry {
+	e := Entry{Parent: n}
+	for c := 0; c < Fanout; c++ {
+		d := r.digest(n.child(c))
+		if d == 0 {
+			continue
+		}
+		e.Mask |= 1 << c
+		if n.Depth+1 < Levels {
+			e.Digests = append(e.Digests, d)
+		}
+	}
+	return e
+}
+
+// Initiate makes r the initiator, and returns the first Message, which
+// describes the children of the root.
+func (r *Reconciler) Initiate() Message {
+	r.initiator = true
+	r.have, r.need = nil, nil
+	return Message{r.entry(Node{})}
+}
+
+// Reconcile processes Message m from the other party, and returns the reply.
+// Each Entry of m describes the children of a node of the other party's
+// trie, and the reply describes the children of those of our children whose
+// digests differ, one level deeper. Messages thus alternate between depths:
+// the initiator sends Entries for nodes at even depths, and the responder
+// for nodes at odd depths. The children of nodes at depth Levels-1 are
+// leaves, which the initiator compares to find Have and Need; a responder
+// that receives such an Entry with a mismatch echoes its own Entry for the
+// node, so that the initiator compares the leaves instead. An empty reply
+// of the initiator ends the protocol, and is not to be sent; the responder
+// must always send its reply.
+func (r *Reconciler) Reconcile(m Message) Message {
+	reply := Message{}
+	for _, e := range m {
+		if e.Parent.Depth < 0 || e.Parent.Depth >= Levels ||
+			uint64(e.Parent.Prefix) >= 1<<(Radix*e.Parent.Depth) {
+			continue
+		}
+		leaves := e.Parent.Depth+1 == Levels
+		i := 0
+		mismatched := false
+		for c := 0; c < Fanout; c++ {
+			child := e.Parent.child(c)
+			var theirs uint64
+			if e.Mask&(1<<c) != 0 {
+				if leaves {
+					theirs = leafDigest(child.Prefix)
+				} else if i < len(e.Digests) {
+					theirs = e.Digests[i]
+				}
+				i += 1
+			}
+			ours := r.digest(child)
+			if ours == theirs {
+				continue
+			}
+			switch {
+			case !leaves:
+				reply = append(reply, r.entry(child))
+			case r.initiator && ours != 0:
+				r.have = append(r.have, child.Prefix)
+			case r.initiator:
+				r.need = append(r.need, child.Prefix)
+			default:
+				mismatched = true
+			}
+		}
+		if mismatched {
+			// let the initiator compare the leaves
+			reply = append(reply, r.entry(e.Parent))
+		}
+	}
+	return reply
+}
+
+// Have returns the source symbols at the leaves of the initiator that are
+// missing from the trie of the responder, as found so far by comparing the
+// children of nodes at depth Levels-1. It is complete once Reconcile returns
+// an empty reply, and always empty for the responder.
+func (r *Reconciler) Have() []HashType {
+	return r.have
+}
+
+// Need returns the source symbols at the leaves of the responder that are
+// missing from the trie of the initiator, as found so far. Like Have, it is
+// complete once Reconcile returns an empty reply.
+func (r *Reconciler) Need() []HashType {
+	return r.need
+}
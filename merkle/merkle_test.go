@@ -0,0 +1,205 @@
+package merkle
+
+import (
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// exchange runs the protocol between an initiator with set a and a responder
+// with set b, sending every Message in its binary encoding, and returns the
+// initiator, the number of round trips, and the number of bytes sent.
+func exchange(tb testing.TB, a, b []HashType) (init *Reconciler, rounds, nbytes int) {
+	init = NewReconciler(a)
+	resp := NewReconciler(b)
+	send := func(m Message) Message {
+		data, _ := m.MarshalBinary()
+		nbytes += len(data)
+		var got Message
+		if err := got.UnmarshalBinary(data); err != nil {
+			tb.Fatal(err)
+		}
+		return got
+	}
+	for m := init.Initiate(); len(m) > 0; m = init.Reconcile(send(resp.Reconcile(send(m)))) {
+		// every round trip descends two levels of the trie
+		if rounds += 1; rounds > (Levels+1)/2 {
+			tb.Fatal("protocol does not terminate")
+		}
+	}
+	return init, rounds, nbytes
+}
+
+// randomSet returns n distinct random source symbols.
+func randomSet(rng *rand.Rand, n int) []HashType {
+	seen := make(map[HashType]bool, n)
+	set := make([]HashType, 0, n)
+	for len(set) < n {
+		if x := rng.Uint32(); !seen[x] {
+			seen[x] = true
+			set = append(set, x)
+		}
+	}
+	return set
+}
+
+func TestReconcile(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	symbols := randomSet(rng, 14000)
+	common, extra := symbols[:10000], symbols[10000:]
+	// x and x^1 are distinct leaves of the same node at depth Levels-1.
+	x := extra[0]
+	cases := []struct {
+		name         string
+		common       []HashType
+		onlyA, onlyB []HashType
+	}{
+		{"empty", nil, nil, nil},
+		{"equal", common, nil, nil},
+		{"only initiator", nil, extra[:100], nil},
+		{"only responder", nil, nil, extra[:100]},
+		{"one missing", common, extra[:1], nil},
+		{"one extra", common, nil, extra[:1]},
+		{"siblings", common, []HashType{x}, []HashType{x ^ 1}},
+		{"many", common, extra[:1000], extra[1000:]},
+	}
+	for _, tc := range cases {
+		a := append(slices.Clone(tc.common), tc.onlyA...)
+		b := append(slices.Clone(tc.common), tc.onlyB...)
+		r, rounds, _ := exchange(t, a, b)
+		have := slices.Clone(r.Have())
+		need := slices.Clone(r.Need())
+		slices.Sort(have)
+		slices.Sort(need)
+		onlyA := slices.Clone(tc.onlyA)
+		onlyB := slices.Clone(tc.onlyB)
+		slices.Sort(onlyA)
+		slices.Sort(onlyB)
+		if !slices.Equal(have, onlyA) || !slices.Equal(need, onlyB) {
+			t.Errorf("%s: found %d and %d differences", tc.name, len(have), len(need))
+		}
+		// Equal tries differ at no child of the root, and any difference
+		// takes the trip to the leaves.
+		want := (Levels + 1) / 2
+		if len(onlyA)+len(onlyB) == 0 {
+			want = 1
+		}
+		if rounds != want {
+			t.Errorf("%s: %d round trips, expected %d", tc.name, rounds, want)
+		}
+	}
+}
+
+func TestReconcileBoundaries(t *testing.T) {
+	a := []HashType{0, 1, 0xfffffffe, 0xffffffff}
+	b := []HashType{0, 0xffffffff}
+	r, _, _ := exchange(t, a, b)
+	if !slices.Equal(r.Have(), []HashType{1, 0xfffffffe}) || len(r.Need()) != 0 {
+		t.Errorf("found %v and %v", r.Have(), r.Need())
+	}
+}
+
+func TestMessageEncoding(t *testing.T) {
+	m := Message{
+		{Node{0, 0}, 0x8001, []uint64{1, 2}},
+		{Node{Levels - 1, 0xfffffff}, 0x0003, nil},
+	}
+	data, _ := m.MarshalBinary()
+	var got Message
+	if err := got.UnmarshalBinary(data); err != nil || len(got) != 2 ||
+		!slices.Equal(got[0].Digests, m[0].Digests) || got[1].Parent != m[1].Parent {
+		t.Fatalf("round trip: %v %v", got, err)
+	}
+	for i := 0; i < len(data); i++ {
+		if got.UnmarshalBinary(data[:i]) == nil {
+			t.Errorf("accepted a prefix of %d bytes", i)
+		}
+	}
+	for _, bad := range []Message{
+		// a leaf as a parent
+		{{Node{Levels, 0}, 1, nil}},
+		// a prefix too long for the depth
+		{{Node{1, Fanout}, 1, []uint64{1}}},
+		// fewer Digests than children in the Mask
+		{{Node{1, 0}, 3, []uint64{1}}},
+	} {
+		data, _ := bad.MarshalBinary()
+		if got.UnmarshalBinary(data) == nil {
+			t.Errorf("accepted %v", bad)
+		}
+	}
+}
+
+// BenchmarkMerkleSync runs the protocol on the workload of
+// BenchmarkQuackDecode and BenchmarkRIBLTDecode: the initiator has d+n
+// consecutive source symbols, and the responder has the first n. It reports
+// the number of round trips, and the bytes sent in units of source symbols
+// per difference, comparable to the symbols/diff of the other packages.
+func BenchmarkMerkleSync(bc *testing.B) {
+	cases := []struct {
+		name string
+		size int
+	}{
+		{"d=10", 10},
+		{"d=20", 20},
+		{"d=40", 40},
+		{"d=80", 80},
+		{"d=160", 160},
+		{"d=320", 320},
+		{"d=1000", 1000},
+		{"d=10000", 10000},
+	}
+	for _, tc := range cases {
+		bc.Run(tc.name, func(b *testing.B) {
+			d := tc.size
+			n := tc.size
+			b.SetBytes(HashTypeSize * int64(d))
+			log := make([]HashType, d + n)
+			nrounds := 0
+			nbytes := 0
+			var nextId HashType
+			b.ResetTimer()
+			b.StopTimer()
+			for iter := 0; iter < b.N; iter++ {
+				for i := 0; i < d + n; i++ {
+					nextId += 1
+					log[i] = nextId
+				}
+				b.StartTimer()
+				_, rounds, bytes := exchange(b, log, log[:n])
+				b.StopTimer()
+				nrounds += rounds
+				nbytes += bytes
+			}
+			b.ReportMetric(float64(nbytes)/float64(HashTypeSize)/float64(b.N*d), "symbols/diff")
+			b.ReportMetric(float64(nrounds)/float64(b.N), "rounds")
+		})
+	}
+}
+
+// BenchmarkMerkleSyncRandom is BenchmarkMerkleSync with random source
+// symbols, which share no prefixes the trie can exploit, and 10000 common
+// source symbols.
+func BenchmarkMerkleSyncRandom(bc *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	for _, d := range []int{10, 100, 1000, 10000} {
+		bc.Run("d="+strconv.Itoa(d), func(b *testing.B) {
+			b.SetBytes(HashTypeSize * int64(d))
+			nrounds := 0
+			nbytes := 0
+			b.ResetTimer()
+			b.StopTimer()
+			for iter := 0; iter < b.N; iter++ {
+				set := randomSet(rng, 10000+d)
+				b.StartTimer()
+				_, rounds, bytes := exchange(b, set, set[:10000])
+				b.StopTimer()
+				nrounds += rounds
+				nbytes += bytes
+			}
+			b.ReportMetric(float64(nbytes)/float64(HashTypeSize)/float64(b.N*d), "symbols/diff")
+			b.ReportMetric(float64(nrounds)/float64(b.N), "rounds")
+		})
+	}
+}
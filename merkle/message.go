@@ -0,0 +1,73 @@
+package merkle
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// ErrInvalidMessage is returned by UnmarshalBinary when the data is corrupt.
+var ErrInvalidMessage = errors.New("merkle: invalid message")
+
+// MarshalBinary implements encoding.BinaryMarshaler. Each Entry is encoded
+// as the depth of its parent in a byte, the prefix of its parent as a
+// uvarint, its Mask in 2 bytes, and its Digests in 8 bytes each.
+func (m Message) MarshalBinary() ([]byte, error) {
+	b := binary.AppendUvarint(nil, uint64(len(m)))
+	for _, e := range m {
+		b = append(b, byte(e.Parent.Depth))
+		b = binary.AppendUvarint(b, uint64(e.Parent.Prefix))
+		b = binary.LittleEndian.AppendUint16(b, e.Mask)
+		for _, d := range e.Digests {
+			b = binary.LittleEndian.AppendUint64(b, d)
+		}
+	}
+	return b, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It checks that the
+// parents are inner nodes of the trie, and that the number of Digests
+// matches the Mask.
+func (m *Message) UnmarshalBinary(data []byte) error {
+	n, l := binary.Uvarint(data)
+	if l <= 0 || n > uint64(len(data)) {
+		return ErrInvalidMessage
+	}
+	data = data[l:]
+	msg := make(Message, 0, n)
+	for i := uint64(0); i < n; i++ {
+		var e Entry
+		if len(data) == 0 || int(data[0]) >= Levels {
+			return ErrInvalidMessage
+		}
+		e.Parent.Depth = int(data[0])
+		prefix, l := binary.Uvarint(data[1:])
+		if l <= 0 || prefix >= 1<<(Radix*e.Parent.Depth) {
+			return ErrInvalidMessage
+		}
+		e.Parent.Prefix = uint32(prefix)
+		data = data[1+l:]
+		if len(data) < 2 {
+			return ErrInvalidMessage
+		}
+		e.Mask = binary.LittleEndian.Uint16(data)
+		data = data[2:]
+		if e.Parent.Depth+1 < Levels {
+			nd := bits.OnesCount16(e.Mask)
+			if len(data) < 8*nd {
+				return ErrInvalidMessage
+			}
+			e.Digests = make([]uint64, nd)
+			for j := range e.Digests {
+				e.Digests[j] = binary.LittleEndian.Uint64(data[8*j:])
+			}
+			data = data[8*nd:]
+		}
+		msg = append(msg, e)
+	}
+	if len(data) != 0 {
+		return ErrInvalidMessage
+	}
+	*m = msg
+	return nil
+}
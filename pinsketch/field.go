@@ -0,0 +1,63 @@
+package pinsketch
+
+type HashType = uint32
+const HashTypeSize int64 = 4
+
+// GF32 is an element of GF(2^32), represented as a polynomial over GF(2)
+// modulo Modulus, with the coefficient of x^i in bit i. Addition and
+// subtraction are both XOR.
+type GF32 uint32
+
+// Modulus is the irreducible polynomial x^32 + x^7 + x^3 + x^2 + 1 that
+// defines GF32, without the x^32 term.
+const Modulus = 1<<7 | 1<<3 | 1<<2 | 1
+
+// reduce returns p modulo x^32 + Modulus, for a polynomial p of degree less
+// than 64.
+func reduce(p uint64) GF32 {
+	// x^32 = Modulus, so the high word h contributes h * Modulus, which has
+	// degree less than 39, and the second round has degree less than 32.
+	for h := p >> 32; h != 0; h = p >> 32 {
+		p = p&0xffffffff ^ h ^ h<<2 ^ h<<3 ^ h<<7
+	}
+	return GF32(p)
+}
+
+// Mul returns a*b.
+func (a GF32) Mul(b GF32) GF32 {
+	var p uint64
+	x := uint64(a)
+	for i := 0; i < 32; i++ {
+		// carryless multiplication, without branching on b
+		p ^= x & -(uint64(b>>i) & 1)
+		x <<= 1
+	}
+	return reduce(p)
+}
+
+// Square returns a*a. Squaring is linear in GF(2^32): it spreads the bits
+// of a to the even positions.
+func (a GF32) Square() GF32 {
+	var p uint64
+	for i := 0; i < 32; i++ {
+		p |= uint64(a>>i&1) << (2 * i)
+	}
+	return reduce(p)
+}
+
+// Pow returns a^e.
+func (a GF32) Pow(e uint64) GF32 {
+	result := GF32(1)
+	for ; e > 0; e >>= 1 {
+		if e&1 == 1 {
+			result = result.Mul(a)
+		}
+		a = a.Square()
+	}
+	return result
+}
+
+// Inv returns the inverse of nonzero a, which is a^(2^32-2).
+func (a GF32) Inv() GF32 {
+	return a.Pow(1<<32 - 2)
+}
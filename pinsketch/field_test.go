@@ -0,0 +1,60 @@
+package pinsketch
+
+import (
+	"math/bits"
+	"math/rand"
+	"testing"
+)
+
+// TestModulusIrreducible checks that x^32 + Modulus is irreducible with
+// Rabin's test: x^(2^32) = x modulo it, and it has no common factor with
+// x^(2^16) - x, since 16 is the only maximal proper divisor of 32.
+// gcd2 returns the greatest common divisor of polynomials a and b over GF(2).
+func gcd2(a, b uint64) uint64 {
+	for b != 0 {
+		// a mod b
+		for bits.Len64(a) >= bits.Len64(b) {
+			a ^= b << (bits.Len64(a) - bits.Len64(b))
+		}
+		a, b = b, a
+	}
+	return a
+}
+
+func TestModulusIrreducible(t *testing.T) {
+	// x^(2^k) is GF32(2) squared k times
+	x := GF32(2)
+	for i := 0; i < 16; i++ {
+		x = x.Square()
+	}
+	if gcd2(1<<32|Modulus, uint64(x^2)) != 1 {
+		t.Errorf("x^32 + Modulus has a factor of degree dividing 16")
+	}
+	for i := 16; i < 32; i++ {
+		x = x.Square()
+	}
+	if x != 2 {
+		t.Errorf("x^(2^32) != x")
+	}
+}
+
+func TestFieldArithmetic(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		a, b, c := GF32(rand.Uint32()), GF32(rand.Uint32()), GF32(rand.Uint32())
+		if a.Mul(b) != b.Mul(a) {
+			t.Fatalf("%d*%d is not commutative", a, b)
+		}
+		if a.Mul(b^c) != a.Mul(b)^a.Mul(c) {
+			t.Fatalf("%d*(%d+%d) is not distributive", a, b, c)
+		}
+		if a.Mul(b).Mul(c) != a.Mul(b.Mul(c)) {
+			t.Fatalf("%d*%d*%d is not associative", a, b, c)
+		}
+		if a.Square() != a.Mul(a) {
+			t.Fatalf("%d^2 is wrong", a)
+		}
+		if a != 0 && a.Mul(a.Inv()) != 1 {
+			t.Fatalf("%d has no inverse", a)
+		}
+	}
+}
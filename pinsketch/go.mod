@@ -0,0 +1,3 @@
+module github.com/ygina/subset-reconciliation/pinsketch
+
+go 1.21
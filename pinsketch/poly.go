@@ -0,0 +1,139 @@
+package pinsketch
+
+// poly is a polynomial over GF32, with the coefficient of z^i at index i.
+// Normalized polys have no trailing zeros, so the zero poly is empty.
+type poly []GF32
+
+// normalize trims the trailing zeros of f.
+func (f poly) normalize() poly {
+	for len(f) > 0 && f[len(f)-1] == 0 {
+		f = f[:len(f)-1]
+	}
+	return f
+}
+
+// degree returns the degree of normalized f, or -1 if f is zero.
+func (f poly) degree() int {
+	return len(f) - 1
+}
+
+// monic returns normalized, nonzero f divided by its leading coefficient.
+func (f poly) monic() poly {
+	inv := f[len(f)-1].Inv()
+	g := make(poly, len(f))
+	for i, c := range f {
+		g[i] = c.Mul(inv)
+	}
+	return g
+}
+
+// square returns f*f. In characteristic 2, the cross terms cancel.
+func (f poly) square() poly {
+	if len(f) == 0 {
+		return nil
+	}
+	g := make(poly, 2*len(f)-1)
+	for i, c := range f {
+		g[2*i] = c.Square()
+	}
+	return g
+}
+
+// divMod returns the quotient and the remainder of normalized f divided by
+// normalized, nonzero g.
+func (f poly) divMod(g poly) (q, r poly) {
+	r = append(poly(nil), f...)
+	if len(r) < len(g) {
+		return nil, r
+	}
+	q = make(poly, len(r)-len(g)+1)
+	inv := g[len(g)-1].Inv()
+	for i := len(q) - 1; i >= 0; i-- {
+		c := r[i+len(g)-1].Mul(inv)
+		q[i] = c
+		for j, b := range g {
+			r[i+j] ^= c.Mul(b)
+		}
+	}
+	return q.normalize(), r[:len(g)-1].normalize()
+}
+
+// mod returns f modulo g.
+func (f poly) mod(g poly) poly {
+	_, r := f.divMod(g)
+	return r
+}
+
+// gcd returns the monic greatest common divisor of normalized f and g, or
+// zero if both are zero.
+func (f poly) gcd(g poly) poly {
+	for len(g) > 0 {
+		f, g = g, f.mod(g)
+	}
+	if len(f) == 0 {
+		return f
+	}
+	return f.monic()
+}
+
+// add returns f+g.
+func (f poly) add(g poly) poly {
+	if len(f) < len(g) {
+		f, g = g, f
+	}
+	h := append(poly(nil), f...)
+	for i, c := range g {
+		h[i] ^= c
+	}
+	return h.normalize()
+}
+
+// roots returns the roots of normalized, nonzero f, and whether f is a
+// product of distinct linear factors, i.e., whether it has deg(f) distinct
+// roots. It finds them by Berlekamp's trace algorithm: for any b, the trace
+//   Tr(b z) = b z + (b z)^2 + (b z)^4 + ... + (b z)^(2^31)
+// is 0 or 1 at every element of GF(2^32), so gcd(f, Tr(b z)) splits f unless
+// Tr(b r) is the same at every root r. The b are a fixed pseudorandom
+// sequence, so roots is deterministic.
+func (f poly) roots() ([]GF32, bool) {
+	f = f.monic()
+	if f.degree() <= 0 {
+		return nil, f.degree() == 0
+	}
+	// f splits into distinct linear factors if and only if it divides
+	// z^(2^32) - z.
+	zq := poly{0, 1}
+	for i := 0; i < 32; i++ {
+		zq = zq.square().mod(f)
+	}
+	if zq.add(poly{0, 1}).mod(f).degree() >= 0 {
+		return nil, false
+	}
+	roots := make([]GF32, 0, f.degree())
+	var seed uint64
+	var split func(f poly)
+	split = func(f poly) {
+		if f.degree() == 1 {
+			roots = append(roots, f[0])
+			return
+		}
+		for {
+			seed += 0x9e3779b97f4a7c15
+			bz := poly{0, GF32(seed >> 32)}.mod(f)
+			tr := bz
+			for i := 1; i < 32; i++ {
+				bz = bz.square().mod(f)
+				tr = tr.add(bz)
+			}
+			g := f.gcd(tr)
+			if g.degree() > 0 && g.degree() < f.degree() {
+				q, _ := f.divMod(g)
+				split(g)
+				split(q)
+				return
+			}
+		}
+	}
+	split(f)
+	return roots, true
+}
@@ -0,0 +1,139 @@
+// Package pinsketch implements PinSketch, a set sketch made of the
+// syndromes of a BCH code over GF(2^32), as in Minisketch, for comparison
+// with QuACK and Rateless IBLTs.
+//
+// A Sketch with capacity c of a set S of nonzero elements of GF(2^32) holds
+// the odd power sums
+//   s_k = sum of x^k over x in S, for k = 1, 3, ..., 2c-1.
+// The even power sums follow from s_2k = s_k^2, since the field has
+// characteristic 2. Adding and removing a symbol are the same operation, so
+// subtracting the Sketch of S2 from that of S yields the Sketch of the
+// symmetric difference, which Decode recovers in full, without a log, as
+// long as it has at most c symbols.
+package pinsketch
+
+// Sketch is a PinSketch of a set of source symbols.
+type Sketch struct {
+	Syndromes []GF32
+}
+
+// NewSketch returns the Sketch of the empty set with capacity c, which can
+// decode sets of up to c symbols.
+func NewSketch(c int) Sketch {
+	return Sketch{Syndromes: make([]GF32, c)}
+}
+
+// AddSymbol inserts source symbol t to the set of which s is a sketch, or
+// deletes it if it is already in the set. t must not be 0.
+func (s Sketch) AddSymbol(t HashType) {
+	if t == 0 {
+		panic("adding symbol 0")
+	}
+	x := GF32(t)
+	x2 := x.Square()
+	for i := range s.Syndromes {
+		s.Syndromes[i] ^= x
+		x = x.Mul(x2)
+	}
+}
+
+// Subtract subtracts s2 from s by modifying s in place. s and s2 must be of
+// equal capacity. If s is a sketch of set S and s2 is a sketch of set S2, then
+// the result is a sketch of the symmetric difference between S and S2.
+func (s Sketch) Subtract(s2 Sketch) {
+	if len(s.Syndromes) != len(s2.Syndromes) {
+		panic("subtracting sketches of different sizes")
+	}
+
+	for i := range s.Syndromes {
+		s.Syndromes[i] ^= s2.Syndromes[i]
+	}
+}
+
+// Decode tries to decode s, where s can be one of the following
+//  1. A sketch of set S.
+//  2. Content of s after calling s.Subtract(s2), where s is a sketch of set
+//     S, and s2 is a sketch of set S2.
+//
+// When successful, indicated by succ being true, diff contains all source
+// symbols in S in case 1, or the symmetric difference between S and S2 in
+// case 2, in no particular order. Decode fails if the set has more symbols
+// than the capacity of s, except with small probability, in which case diff
+// is wrong.
+func (s Sketch) Decode() (diff []HashType, succ bool) {
+	c := len(s.Syndromes)
+	// the power sums s_1, ..., s_2c
+	sums := make([]GF32, 2*c)
+	for i := range sums {
+		k := i + 1
+		if k%2 == 1 {
+			sums[i] = s.Syndromes[k/2]
+		} else {
+			sums[i] = sums[k/2-1].Square()
+		}
+	}
+	locator, n := berlekampMassey(sums)
+	// The locator is the product of 1 - x z over the n symbols x, so it has
+	// degree n, and its reverse is the product of z - x, whose roots are the
+	// symbols.
+	if n > c || locator.degree() != n {
+		return nil, false
+	}
+	rev := make(poly, n+1)
+	for i, a := range locator {
+		rev[n-i] = a
+	}
+	roots, ok := rev.roots()
+	if !ok || len(roots) != n {
+		return nil, false
+	}
+	diff = make([]HashType, n)
+	for i, r := range roots {
+		if r == 0 {
+			return nil, false
+		}
+		diff[i] = HashType(r)
+	}
+	return diff, true
+}
+
+// berlekampMassey returns the shortest linear feedback shift register that
+// generates s, as its connection polynomial C with C[0] = 1 and its length L,
+// such that
+//   s[n] + C[1] s[n-1] + ... + C[L] s[n-L] = 0
+// for all n from L. L is at least the degree of C.
+func berlekampMassey(s []GF32) (poly, int) {
+	c := poly{1}
+	b := poly{1}
+	l := 0
+	m := 1
+	bd := GF32(1)
+	for n := range s {
+		// discrepancy of the next term
+		d := s[n]
+		for i := 1; i <= l && i < len(c); i++ {
+			d ^= c[i].Mul(s[n-i])
+		}
+		if d == 0 {
+			m += 1
+			continue
+		}
+		coef := d.Mul(bd.Inv())
+		t := c
+		// c - coef z^m b
+		shifted := make(poly, m+len(b))
+		for i, a := range b {
+			shifted[m+i] = coef.Mul(a)
+		}
+		c = c.add(shifted)
+		if 2*l <= n {
+			l = n + 1 - l
+			b = t
+			bd = d
+			m = 1
+		} else {
+			m += 1
+		}
+	}
+	return c, l
+}
@@ -0,0 +1,162 @@
+package pinsketch
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func BenchmarkPinSketchEncode(b *testing.B) {
+	benches := []struct {
+		name string
+		size int
+	}{
+		{"m=10", 10},
+		{"m=20", 20},
+		{"m=40", 40},
+		{"m=80", 80},
+		{"m=160", 160},
+		{"m=320", 320},
+		{"m=1000", 1000},
+		{"m=10000", 10000},
+	}
+	for _, bench := range benches {
+		s := NewSketch(bench.size)
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(HashTypeSize)
+			for i := 0; i < b.N; i++ {
+				s.AddSymbol(HashType(i) | 1)
+			}
+		})
+	}
+}
+
+func BenchmarkPinSketchDecode(bc *testing.B) {
+	cases := []struct {
+		name string
+		size int
+	}{
+		{"d=10", 10},
+		{"d=20", 20},
+		{"d=40", 40},
+		{"d=80", 80},
+		{"d=160", 160},
+		{"d=320", 320},
+		// {"d=1000", 1000},
+		// {"d=10000", 10000},
+	}
+	for _, tc := range cases {
+		bc.Run(tc.name, func(b *testing.B) {
+			d := tc.size
+			n := tc.size
+			b.SetBytes(HashTypeSize * int64(d))
+			var nextId uint32
+			b.ResetTimer()
+			b.StopTimer()
+			for iter := 0; iter < b.N; iter++ {
+				slocal := NewSketch(d)
+				sremote := NewSketch(d)
+				for i := 0; i < d + n; i++ {
+					nextId += 1
+					slocal.AddSymbol(nextId)
+					if i < n {
+						sremote.AddSymbol(nextId)
+					}
+				}
+
+				// Decode
+				b.StartTimer()
+				slocal.Subtract(sremote)
+				slocal.Decode()
+				b.StopTimer()
+			}
+			b.ReportMetric(1, "symbols/diff")
+		})
+	}
+}
+
+func TestPolyRoots(t *testing.T) {
+	want := []GF32{1, 2, 3, 0xffffffff, 123456789}
+	f := poly{1}
+	for _, r := range want {
+		// f * (z + r)
+		g := make(poly, len(f)+1)
+		for i, c := range f {
+			g[i] ^= c.Mul(r)
+			g[i+1] ^= c
+		}
+		f = g
+	}
+	roots, ok := f.roots()
+	slices.Sort(roots)
+	slices.Sort(want)
+	if !ok || !slices.Equal(roots, want) {
+		t.Errorf("roots are %v %t, expected %v", roots, ok, want)
+	}
+	// z^2 + z + b has no roots for the half of the b with trace 1
+	nosplit := 0
+	for i := 0; i < 100; i++ {
+		if _, ok := (poly{GF32(rand.Uint32()), 1, 1}).roots(); !ok {
+			nosplit += 1
+		}
+	}
+	if nosplit < 25 || nosplit > 75 {
+		t.Errorf("%d of 100 random z^2 + z + b do not split", nosplit)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, tc := range []struct{ c, n, na, nb int }{
+		{10, 0, 0, 0},
+		{10, 100, 0, 0},
+		{10, 100, 10, 0},
+		{10, 100, 4, 6},
+		{40, 1000, 13, 20},
+		{100, 100, 50, 50},
+	} {
+		s1, s2 := NewSketch(tc.c), NewSketch(tc.c)
+		var want []HashType
+		for i := 0; i < tc.n+tc.na+tc.nb; i++ {
+			x := rand.Uint32() | 1
+			if i < tc.n+tc.na {
+				s1.AddSymbol(x)
+			}
+			if i < tc.n || i >= tc.n+tc.na {
+				s2.AddSymbol(x)
+			}
+			if i >= tc.n {
+				want = append(want, x)
+			}
+		}
+		s1.Subtract(s2)
+		diff, succ := s1.Decode()
+		slices.Sort(diff)
+		slices.Sort(want)
+		if !succ || !slices.Equal(diff, want) {
+			t.Errorf("%+v: decoded %v %t, expected %v", tc, diff, succ, want)
+		}
+	}
+}
+
+func TestDecodeFailure(t *testing.T) {
+	for _, n := range []int{11, 12, 20, 100} {
+		s := NewSketch(10)
+		for i := 0; i < n; i++ {
+			s.AddSymbol(rand.Uint32() | 1)
+		}
+		if diff, succ := s.Decode(); succ {
+			t.Errorf("n=%d: decoded %v beyond the capacity", n, diff)
+		}
+	}
+}
+
+func TestAddTwice(t *testing.T) {
+	s := NewSketch(5)
+	s.AddSymbol(7)
+	s.AddSymbol(9)
+	s.AddSymbol(7)
+	diff, succ := s.Decode()
+	if !succ || !slices.Equal(diff, []HashType{9}) {
+		t.Errorf("decoded %v %t", diff, succ)
+	}
+}
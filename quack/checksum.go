@@ -0,0 +1,60 @@
+package quack
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/dchest/siphash"
+)
+
+// ChecksumKey is a secret that keys the checksum of a Sketch. Decode finds
+// the missing symbols by testing the symbols in the log for being roots of a
+// polynomial, so a symbol that is a root only by accident, or that collides
+// with a missing symbol, is reported as missing, and Decode cannot tell. The
+// checksum of a Sketch is the sum, modulo 2^64, of a 64-bit hash of each
+// symbol keyed by the ChecksumKey, and is independent of the power sums. A
+// Sketch created with NewSketchWithChecksum maintains it, and Decode checks
+// that the missing symbols it finds add up to it before reporting success.
+//
+// The sender and the receiver must use the same ChecksumKey. As long as the
+// ChecksumKey is hidden from the adversary, a wrong answer passes the check
+// with probability about 2^-64.
+type ChecksumKey [16]byte
+
+// NewChecksumKey returns a random ChecksumKey from a cryptographically secure
+// source.
+func NewChecksumKey() (ChecksumKey, error) {
+	var k ChecksumKey
+	_, err := rand.Read(k[:])
+	return k, err
+}
+
+// hash returns the keyed hash of symbol t.
+func (k *ChecksumKey) hash(t HashType) uint64 {
+	var buf [HashTypeSize]byte
+	binary.LittleEndian.PutUint32(buf[:], t)
+	k0 := binary.LittleEndian.Uint64(k[0:8])
+	k1 := binary.LittleEndian.Uint64(k[8:16])
+	return siphash.Hash(k0, k1, buf[:])
+}
+
+// NewSketchWithChecksum returns an empty Sketch with threshold d that
+// maintains a checksum keyed by k. See type ChecksumKey.
+func NewSketchWithChecksum(d int, k ChecksumKey) Sketch {
+	s := NewSketch(d)
+	s.ChecksumKey = &k
+	return s
+}
+
+// VerifyChecksum reports whether the symbols in missing add up to the
+// checksum of s, where s is as described in Decode. It is always true if s
+// has no checksum.
+func (s Sketch) VerifyChecksum(missing []HashType) bool {
+	if s.ChecksumKey == nil {
+		return true
+	}
+	var sum uint64
+	for _, x := range missing {
+		sum += s.ChecksumKey.hash(x)
+	}
+	return sum == s.Checksum
+}
@@ -0,0 +1,73 @@
+package quack
+
+import (
+	"testing"
+)
+
+func TestChecksumDecode(t *testing.T) {
+	const (
+		x1 uint32 = 3616712547
+		x2 uint32 = 2333013068
+		x3 uint32 = 2234311686
+		x4 uint32 = 448751902
+	)
+
+	d := 20
+	key := ChecksumKey{1, 2, 3}
+	InitInverseTableUint32(d)
+	s1 := NewSketchWithChecksum(d, key)
+	s2 := NewSketchWithChecksum(d, key)
+	for _, x := range []uint32{x1, x2, x3, x4} {
+		s1.AddSymbol(x)
+	}
+	s2.AddSymbol(x4)
+	s1.Subtract(s2)
+
+	fwd, succ := s1.Decode([]HashType{x1, x2, x3, x4})
+	checkDecode(t, fwd, succ, []HashType{x1, x2, x3}, true)
+
+	// not all roots are in log
+	fwd, succ = s1.Decode([]HashType{x1, x2, x4})
+	checkDecode(t, fwd, succ, []HashType{x1, x2}, false)
+
+	// same without the checksum
+	s1.ChecksumKey = nil
+	fwd, succ = s1.Decode([]HashType{x1, x2, x4})
+	checkDecode(t, fwd, succ, []HashType{x1, x2}, true)
+}
+
+func TestChecksumCollision(t *testing.T) {
+	// x and y are equal modulo the prime, so they are both roots of the
+	// polynomial of a sketch with either of them.
+	const x uint32 = 3
+	const y uint32 = x + ModulusUint32Small
+
+	d := 10
+	InitInverseTableUint32(d)
+	s := NewSketch(d)
+	s.AddSymbol(y)
+	fwd, succ := s.Decode([]HashType{x})
+	checkDecode(t, fwd, succ, []HashType{x}, true)
+
+	s = NewSketchWithChecksum(d, ChecksumKey{4})
+	s.AddSymbol(y)
+	fwd, succ = s.Decode([]HashType{x})
+	checkDecode(t, fwd, succ, []HashType{x}, false)
+	fwd, succ = s.Decode([]HashType{x, y})
+	if succ {
+		t.Errorf("decoded %v despite the collision", fwd)
+	}
+	fwd, succ = s.Decode([]HashType{y})
+	checkDecode(t, fwd, succ, []HashType{y}, true)
+}
+
+func TestChecksumKeyMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("subtracting sketches with different keys did not panic")
+		}
+	}()
+	s1 := NewSketchWithChecksum(10, ChecksumKey{1})
+	s2 := NewSketchWithChecksum(10, ChecksumKey{2})
+	s1.Subtract(s2)
+}
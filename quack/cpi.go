@@ -0,0 +1,192 @@
+package quack
+
+// CPISketch is a sketch of a set by the values of its characteristic
+// polynomial
+//   chi_S(z) = (z - x_1) (z - x_2) ... (z - x_n)
+// at d fixed evaluation points, where x_1, ..., x_n are the symbols in S, as
+// in characteristic polynomial interpolation (CPI) by Minsky, Trachtenberg,
+// and Zippel. Unlike a Sketch, whose Decode requires one set to be a subset
+// of the other, the CPISketches of any two sets S and S2 with threshold d
+// suffice for computing S \ S2 and S2 \ S, as long as the symmetric
+// difference has at most d symbols.
+//
+// The evaluation points are the d largest elements of the field,
+// ModulusUint32Small-1, ModulusUint32Small-2, and so on. Symbols equal to an
+// evaluation point are supported, but each costs the information of that
+// point. Symbols not less than ModulusUint32Small are reduced modulo it, as
+// in a Sketch, and are decoded as the reduced value.
+type CPISketch struct {
+	Evaluations []ModUint32
+	Count       uint32
+}
+
+// NewCPISketch returns the CPISketch of the empty set with threshold d.
+func NewCPISketch(d int) CPISketch {
+	s := CPISketch{Evaluations: make([]ModUint32, d)}
+	for i := range s.Evaluations {
+		s.Evaluations[i] = 1
+	}
+	return s
+}
+
+// cpiPoint returns the i-th evaluation point.
+func cpiPoint(i int) ModUint32 {
+	return ModUint32(ModulusUint32Small - 1 - uint32(i))
+}
+
+// AddSymbol inserts source symbol t to the set of which s is a sketch.
+func (s *CPISketch) AddSymbol(t HashType) {
+	x := NewModUint32(t)
+	for i := range s.Evaluations {
+		s.Evaluations[i].MulAssign(cpiPoint(i).Sub(x))
+	}
+	s.Count += 1
+}
+
+// Decode tries to compute the symmetric difference between S and S2, where s
+// is a CPISketch of set S and s2 is a CPISketch of set S2 with the same
+// threshold. When successful, indicated by succ being true, fwd contains the
+// source symbols in S \ S2 and rev contains those in S2 \ S, each in no
+// particular order.
+//
+// Decode fails if the symmetric difference has more symbols than the
+// threshold. It detects so with high probability if the symmetric difference
+// has fewer than the threshold symbols, as then the evaluation points are
+// more than enough, and the extra ones check the answer. Otherwise, it only
+// checks that the answer consists of distinct elements of the field, which
+// is likely, but not certain, to catch a wrong answer, so thresholds should
+// leave some margin.
+func (s CPISketch) Decode(s2 CPISketch) (fwd []HashType, rev []HashType, succ bool) {
+	if len(s.Evaluations) != len(s2.Evaluations) {
+		panic("decoding sketches of different sizes")
+	}
+	d := len(s.Evaluations)
+	// S \ S2 has delta more symbols than S2 \ S.
+	delta := int64(s.Count) - int64(s2.Count)
+	da, db := int(max(delta, 0)), int(max(-delta, 0))
+	if int64(da)+int64(db) > int64(d) {
+		return nil, nil, false
+	}
+
+	// Find the smallest k such that there are P of degree da+k and Q of
+	// degree db+k with P/Q = chi_S/chi_S2 at the evaluation points. It exists
+	// for every k at least that for the actual difference, and not for any
+	// smaller k, so binary search works.
+	lo, hi := 0, (d-da-db)/2+1
+	var p, q poly
+	for lo < hi {
+		k := (lo + hi) / 2
+		if pk, qk, ok := s.interpolate(s2, da+k, db+k); ok {
+			hi = k
+			p, q = pk, qk
+		} else {
+			lo = k + 1
+		}
+	}
+	if p == nil {
+		return nil, nil, false
+	}
+	// cancel the common factors, if the evaluation points lacked
+	// information to pin them down
+	g := p.gcd(q)
+	p, _ = p.divMod(g)
+	q, _ = q.divMod(g)
+
+	proots, ok := p.roots()
+	if !ok {
+		return nil, nil, false
+	}
+	qroots, ok := q.roots()
+	if !ok {
+		return nil, nil, false
+	}
+	fwd = make([]HashType, len(proots))
+	for i, r := range proots {
+		fwd[i] = HashType(r)
+	}
+	rev = make([]HashType, len(qroots))
+	for i, r := range qroots {
+		rev[i] = HashType(r)
+	}
+	return fwd, rev, true
+}
+
+// interpolate finds monic P of degree da and monic Q of degree db such that
+//   P(z) chi_S2(z) = Q(z) chi_S(z)
+// at all the evaluation points, and reports whether they exist. The equation
+// is linear in the coefficients of P and Q, and is solved by Gaussian
+// elimination, setting free coefficients to 0.
+func (s CPISketch) interpolate(s2 CPISketch, da, db int) (p, q poly, ok bool) {
+	d := len(s.Evaluations)
+	n := da + db
+	// row i: sum_j p_j b z^j - sum_j q_j a z^j = a z^db - b z^da, where
+	// a = chi_S(z) and b = chi_S2(z) for the i-th evaluation point z
+	rows := make([][]ModUint32, d)
+	for i := range rows {
+		z := cpiPoint(i)
+		a, b := s.Evaluations[i], s2.Evaluations[i]
+		row := make([]ModUint32, n+1)
+		zj := ModUint32(1)
+		for j := 0; j <= max(da, db); j++ {
+			if j < da {
+				row[j] = b.Mul(zj)
+			}
+			if j < db {
+				row[da+j] = a.Mul(zj).Neg()
+			}
+			if j == db {
+				row[n].AddAssign(a.Mul(zj))
+			}
+			if j == da {
+				row[n].SubAssign(b.Mul(zj))
+			}
+			zj.MulAssign(z)
+		}
+		rows[i] = row
+	}
+
+	// reduce to row echelon form
+	pivots := make([]int, 0, n)
+	r := 0
+	for c := 0; c < n && r < d; c++ {
+		pr := -1
+		for i := r; i < d; i++ {
+			if rows[i][c] != 0 {
+				pr = i
+				break
+			}
+		}
+		if pr < 0 {
+			continue
+		}
+		rows[r], rows[pr] = rows[pr], rows[r]
+		inv := rows[r][c].Inv()
+		for j := c; j <= n; j++ {
+			rows[r][j].MulAssign(inv)
+		}
+		for i := 0; i < d; i++ {
+			if i == r || rows[i][c] == 0 {
+				continue
+			}
+			f := rows[i][c]
+			for j := c; j <= n; j++ {
+				rows[i][j].SubAssign(f.Mul(rows[r][j]))
+			}
+		}
+		pivots = append(pivots, c)
+		r += 1
+	}
+	for i := r; i < d; i++ {
+		if rows[i][n] != 0 {
+			return nil, nil, false
+		}
+	}
+
+	x := make([]ModUint32, n)
+	for i, c := range pivots {
+		x[c] = rows[i][n]
+	}
+	p = append(append(poly{}, x[:da]...), 1)
+	q = append(append(poly{}, x[da:]...), 1)
+	return p, q, true
+}
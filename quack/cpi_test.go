@@ -0,0 +1,117 @@
+package quack
+
+import (
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestPolyRoots(t *testing.T) {
+	want := []ModUint32{0, 1, 5, ModUint32(ModulusUint32Small - 1), 123456789}
+	f := poly{1}
+	for _, r := range want {
+		f = f.mul(poly{r.Neg(), 1})
+	}
+	roots, ok := f.roots()
+	slices.Sort(roots)
+	slices.Sort(want)
+	if !ok || !slices.Equal(roots, want) {
+		t.Errorf("roots are %v %t, expected %v", roots, ok, want)
+	}
+	// a repeated root
+	if _, ok := f.mul(poly{ModUint32(5).Neg(), 1}).roots(); ok {
+		t.Errorf("found distinct roots of a poly with a repeated root")
+	}
+	// z^2 + 1 has no roots since p = 3 mod 4
+	if _, ok := (poly{1, 0, 1}).roots(); ok {
+		t.Errorf("found roots of an irreducible poly")
+	}
+}
+
+// newCPISets returns CPISketches with threshold d of random sets S and S2
+// with n common symbols, na symbols only in S, and nb symbols only in S2,
+// and the symbols only in S and only in S2, sorted.
+func newCPISets(d, n, na, nb int) (s, s2 CPISketch, a, b []HashType) {
+	s, s2 = NewCPISketch(d), NewCPISketch(d)
+	for i := 0; i < n+na+nb; i++ {
+		x := HashType(rand.Int63n(int64(ModulusUint32Small)))
+		switch {
+		case i < n:
+			s.AddSymbol(x)
+			s2.AddSymbol(x)
+		case i < n+na:
+			s.AddSymbol(x)
+			a = append(a, x)
+		default:
+			s2.AddSymbol(x)
+			b = append(b, x)
+		}
+	}
+	slices.Sort(a)
+	slices.Sort(b)
+	return
+}
+
+func TestCPIDecode(t *testing.T) {
+	cases := []struct {
+		d, n, na, nb int
+	}{
+		{10, 0, 0, 0},
+		{10, 100, 0, 0},
+		{10, 100, 3, 0},
+		{10, 100, 0, 3},
+		{10, 100, 4, 5},
+		{10, 100, 10, 0},
+		{10, 100, 5, 5},
+		{40, 1000, 20, 7},
+		{100, 1000, 49, 50},
+	}
+	for _, tc := range cases {
+		s, s2, a, b := newCPISets(tc.d, tc.n, tc.na, tc.nb)
+		fwd, rev, succ := s.Decode(s2)
+		slices.Sort(fwd)
+		slices.Sort(rev)
+		if !succ || !slices.Equal(fwd, a) || !slices.Equal(rev, b) {
+			t.Errorf("%+v: decoded %v %v %t, expected %v %v", tc, fwd, rev, succ, a, b)
+		}
+	}
+}
+
+func TestCPIDecodeFailure(t *testing.T) {
+	for _, tc := range []struct{ na, nb int }{{11, 0}, {6, 6}, {20, 20}} {
+		s, s2, _, _ := newCPISets(10, 100, tc.na, tc.nb)
+		if fwd, rev, succ := s.Decode(s2); succ {
+			t.Errorf("%+v: decoded %v %v beyond the threshold", tc, fwd, rev)
+		}
+	}
+}
+
+func TestCPIEvaluationPoint(t *testing.T) {
+	s, s2 := NewCPISketch(10), NewCPISketch(10)
+	both := HashType(cpiPoint(0))
+	only := HashType(cpiPoint(1))
+	s.AddSymbol(both)
+	s2.AddSymbol(both)
+	s.AddSymbol(only)
+	s.AddSymbol(1)
+	s2.AddSymbol(2)
+	fwd, rev, succ := s.Decode(s2)
+	slices.Sort(fwd)
+	if !succ || !slices.Equal(fwd, []HashType{1, only}) || !slices.Equal(rev, []HashType{2}) {
+		t.Errorf("decoded %v %v %t", fwd, rev, succ)
+	}
+}
+
+func BenchmarkCPIDecode(bc *testing.B) {
+	for _, d := range []int{10, 20, 40, 80, 160} {
+		bc.Run("d="+strconv.Itoa(d), func(b *testing.B) {
+			s, s2, _, _ := newCPISets(d, d, d/2, d/2)
+			b.SetBytes(HashTypeSize * int64(d))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.Decode(s2)
+			}
+		})
+	}
+}
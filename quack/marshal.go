@@ -0,0 +1,116 @@
+package quack
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrInvalidSketch is returned by UnmarshalBinary when the data is corrupt,
+// or when the sketch and the receiver disagree on having a checksum.
+var ErrInvalidSketch = errors.New("quack: invalid sketch")
+
+// MaxUnmarshalThreshold is the largest threshold UnmarshalBinary accepts. A
+// truncated sketch is much shorter than its threshold, so without a bound, a
+// short message could make the receiver allocate and compute an arbitrary
+// number of power sums.
+const MaxUnmarshalThreshold = 1 << 16
+
+// MarshalBinary implements encoding.BinaryMarshaler. The encoding holds the
+// threshold, Count, all the power sums, and the checksum if s has one (but
+// never the ChecksumKey).
+func (s Sketch) MarshalBinary() ([]byte, error) {
+	return s.marshal(len(s.PowerSums)), nil
+}
+
+// MarshalTruncated is MarshalBinary, except that it only encodes the first
+// min(Count, threshold) power sums. The power sums of a set of Count symbols
+// beyond the first Count are determined by the first Count (by Newton's
+// identities), so UnmarshalBinary reconstructs them, and the result is equal
+// to s. Senders whose sets are usually much smaller than the threshold save
+// most of the bandwidth this way.
+//
+// s must be a sketch of a set, not the result of Subtract.
+func (s Sketch) MarshalTruncated() []byte {
+	n := len(s.PowerSums)
+	if int64(s.Count) < int64(n) {
+		n = int(s.Count)
+	}
+	return s.marshal(n)
+}
+
+// marshal encodes s with its first n power sums.
+func (s Sketch) marshal(n int) []byte {
+	b := binary.AppendUvarint(nil, uint64(len(s.PowerSums)))
+	b = binary.AppendUvarint(b, uint64(s.Count))
+	b = binary.AppendUvarint(b, uint64(n))
+	for _, p := range s.PowerSums[:n] {
+		b = binary.LittleEndian.AppendUint32(b, uint32(p))
+	}
+	if s.ChecksumKey != nil {
+		b = append(b, 1)
+		b = binary.LittleEndian.AppendUint64(b, s.Checksum)
+	} else {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It accepts the
+// output of both MarshalBinary and MarshalTruncated, and always sets s to a
+// full-size Sketch, which can be subtracted from or decoded. The threshold
+// must be at most MaxUnmarshalThreshold. It keeps the ChecksumKey of s, which
+// must be nil if and only if the encoded sketch has no checksum. On error, s
+// is unchanged.
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	var fields [3]uint64
+	for i := range fields {
+		v, l := binary.Uvarint(data)
+		if l <= 0 {
+			return ErrInvalidSketch
+		}
+		fields[i] = v
+		data = data[l:]
+	}
+	d, count, n := fields[0], fields[1], fields[2]
+	if d > MaxUnmarshalThreshold || count > uint64(^uint32(0)) ||
+		n > d || n != d && n != count ||
+		uint64(len(data)) != 4*n+1 && uint64(len(data)) != 4*n+9 {
+		return ErrInvalidSketch
+	}
+	hasChecksum := uint64(len(data)) == 4*n+9
+	if flag := data[4*n]; flag > 1 || (flag == 1) != hasChecksum ||
+		hasChecksum != (s.ChecksumKey != nil) {
+		return ErrInvalidSketch
+	}
+
+	sums := make([]ModUint32, d)
+	for i := range sums[:n] {
+		p := binary.LittleEndian.Uint32(data[4*i:])
+		if p >= ModulusUint32Small {
+			return ErrInvalidSketch
+		}
+		sums[i] = ModUint32(p)
+	}
+	extendPowerSums(sums, int(n))
+	s.PowerSums = sums
+	s.Count = uint32(count)
+	s.notSubset = false
+	s.Checksum = 0
+	if hasChecksum {
+		s.Checksum = binary.LittleEndian.Uint64(data[4*n+1:])
+	}
+	return nil
+}
+
+// extendPowerSums fills in sums[n:], given that sums[:n] are the first n
+// power sums of a set of n symbols. See newtonPowerSum.
+func extendPowerSums(sums []ModUint32, n int) {
+	if n == 0 || n == len(sums) {
+		// The power sums of the empty set are all 0.
+		return
+	}
+	coeffs := Sketch{PowerSums: sums[:n], Count: uint32(n)}.ToCoeffs()
+	for k := n; k < len(sums); k++ {
+		sums[k] = newtonPowerSum(coeffs, sums, k)
+	}
+}
@@ -0,0 +1,104 @@
+package quack
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestMarshalTruncated(t *testing.T) {
+	d := 50
+	InitInverseTableUint32(d)
+	for _, count := range []int{0, 1, 2, 10, 49, 50, 51, 200} {
+		s := NewSketch(d)
+		for i := 0; i < count; i++ {
+			s.AddSymbol(rand.Uint32())
+		}
+		full, _ := s.MarshalBinary()
+		truncated := s.MarshalTruncated()
+		if count < d && len(truncated) > len(full)-4*(d-count) {
+			t.Errorf("count=%d: truncated to %d bytes, full is %d bytes", count, len(truncated), len(full))
+		}
+		for _, b := range [][]byte{full, truncated} {
+			var r Sketch
+			if err := r.UnmarshalBinary(b); err != nil {
+				t.Fatalf("count=%d: %v", count, err)
+			}
+			if r.Count != s.Count || !slices.Equal(r.PowerSums, s.PowerSums) {
+				t.Errorf("count=%d: unmarshaled sketch differs", count)
+			}
+		}
+	}
+}
+
+func TestMarshalTruncatedDecode(t *testing.T) {
+	d := 20
+	InitInverseTableUint32(d)
+	key := ChecksumKey{7}
+	for _, n := range []int{0, 5, 15} {
+		log := make([]HashType, n+d/2)
+		local := NewSketchWithChecksum(d, key)
+		remote := NewSketchWithChecksum(d, key)
+		for i := range log {
+			log[i] = rand.Uint32()
+			local.AddSymbol(log[i])
+			if i < n {
+				remote.AddSymbol(log[i])
+			}
+		}
+		received := Sketch{ChecksumKey: &key}
+		if err := received.UnmarshalBinary(remote.MarshalTruncated()); err != nil {
+			t.Fatalf("n=%d: %v", n, err)
+		}
+		if received.Checksum != remote.Checksum {
+			t.Errorf("n=%d: checksum differs", n)
+		}
+
+		expected := local
+		expected.PowerSums = slices.Clone(local.PowerSums)
+		expected.Subtract(remote)
+		local.Subtract(received)
+		want, wantSucc := expected.Decode(log)
+		got, succ := local.Decode(log)
+		if succ != wantSucc || !slices.Equal(got, want) || !succ {
+			t.Errorf("n=%d: decoded %v %t, expected %v %t", n, got, succ, want, wantSucc)
+		}
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	s := NewSketch(10)
+	s.AddSymbol(1)
+	s.AddSymbol(2)
+	b := s.MarshalTruncated()
+	var r Sketch
+	for i := 0; i < len(b); i++ {
+		if r.UnmarshalBinary(b[:i]) == nil {
+			t.Errorf("accepted a prefix of %d bytes", i)
+		}
+	}
+	if r.UnmarshalBinary(append(b, 0)) == nil {
+		t.Errorf("accepted trailing data")
+	}
+	// thresholds that are too large to allocate
+	for _, d := range []uint64{MaxUnmarshalThreshold + 1, 1 << 62} {
+		huge := binary.AppendUvarint(nil, d)
+		huge = append(huge, b[1:]...)
+		if r.UnmarshalBinary(huge) != ErrInvalidSketch {
+			t.Errorf("accepted threshold %d", d)
+		}
+	}
+	// a checksum without a ChecksumKey, and vice versa
+	c := NewSketchWithChecksum(10, ChecksumKey{})
+	if r.UnmarshalBinary(c.MarshalTruncated()) == nil {
+		t.Errorf("accepted a checksum without a ChecksumKey")
+	}
+	r.ChecksumKey = &ChecksumKey{}
+	if r.UnmarshalBinary(b) == nil {
+		t.Errorf("accepted a sketch without a checksum")
+	}
+	if r.PowerSums != nil {
+		t.Errorf("modified the sketch on error")
+	}
+}
@@ -0,0 +1,140 @@
+package quack
+
+// poly is a polynomial over ModUint32, with the coefficient of z^i at index
+// i. Normalized polys have no trailing zeros, so the zero poly is empty.
+type poly []ModUint32
+
+// normalize trims the trailing zeros of f.
+func (f poly) normalize() poly {
+	for len(f) > 0 && f[len(f)-1] == 0 {
+		f = f[:len(f)-1]
+	}
+	return f
+}
+
+// degree returns the degree of normalized f, or -1 if f is zero.
+func (f poly) degree() int {
+	return len(f) - 1
+}
+
+// monic returns normalized, nonzero f divided by its leading coefficient.
+func (f poly) monic() poly {
+	inv := f[len(f)-1].Inv()
+	g := make(poly, len(f))
+	for i, c := range f {
+		g[i] = c.Mul(inv)
+	}
+	return g
+}
+
+// mul returns f*g.
+func (f poly) mul(g poly) poly {
+	if len(f) == 0 || len(g) == 0 {
+		return nil
+	}
+	h := make(poly, len(f)+len(g)-1)
+	for i, a := range f {
+		for j, b := range g {
+			h[i+j].AddAssign(a.Mul(b))
+		}
+	}
+	return h.normalize()
+}
+
+// divMod returns the quotient and the remainder of normalized f divided by
+// normalized, nonzero g.
+func (f poly) divMod(g poly) (q, r poly) {
+	r = append(poly(nil), f...)
+	if len(r) < len(g) {
+		return nil, r
+	}
+	q = make(poly, len(r)-len(g)+1)
+	inv := g[len(g)-1].Inv()
+	for i := len(q) - 1; i >= 0; i-- {
+		c := r[i+len(g)-1].Mul(inv)
+		q[i] = c
+		for j, b := range g {
+			r[i+j].SubAssign(c.Mul(b))
+		}
+	}
+	return q.normalize(), r[:len(g)-1].normalize()
+}
+
+// mod returns f modulo g.
+func (f poly) mod(g poly) poly {
+	_, r := f.divMod(g)
+	return r
+}
+
+// gcd returns the monic greatest common divisor of normalized f and g, or
+// zero if both are zero.
+func (f poly) gcd(g poly) poly {
+	for len(g) > 0 {
+		f, g = g, f.mod(g)
+	}
+	if len(f) == 0 {
+		return f
+	}
+	return f.monic()
+}
+
+// powMod returns f^e modulo m.
+func (f poly) powMod(e uint64, m poly) poly {
+	result := poly{1}.mod(m)
+	base := f.mod(m)
+	for ; e > 0; e >>= 1 {
+		if e&1 == 1 {
+			result = result.mul(base).mod(m)
+		}
+		base = base.mul(base).mod(m)
+	}
+	return result
+}
+
+// roots returns the roots of normalized, nonzero f, and whether f is a
+// product of distinct linear factors, i.e., whether it has deg(f) distinct
+// roots. It finds them by Cantor-Zassenhaus equal-degree splitting, with a
+// fixed sequence of pseudorandom shifts, so it is deterministic.
+func (f poly) roots() ([]ModUint32, bool) {
+	f = f.monic()
+	if f.degree() <= 0 {
+		return nil, f.degree() == 0
+	}
+	// gcd(f, z^p - z) is the product of the distinct linear factors of f.
+	zp := poly{0, 1}.powMod(ModulusUint32Big, f)
+	if len(zp) < 2 {
+		zp = append(zp, make(poly, 2-len(zp))...)
+	}
+	zp[1].SubAssign(1)
+	if f.gcd(zp.normalize()).degree() != f.degree() {
+		return nil, false
+	}
+	roots := make([]ModUint32, 0, f.degree())
+	var seed uint64
+	var split func(f poly)
+	split = func(f poly) {
+		if f.degree() == 1 {
+			roots = append(roots, f[0].Neg())
+			return
+		}
+		for {
+			seed += 0x9e3779b97f4a7c15
+			a := NewModUint32(uint32(seed >> 32))
+			// Half the roots r of f are those with (r+a)^((p-1)/2) = 1.
+			h := poly{a, 1}.powMod((ModulusUint32Big-1)/2, f)
+			if len(h) == 0 {
+				continue
+			}
+			h[0].SubAssign(1)
+			g := f.gcd(h.normalize())
+			if g.degree() > 0 && g.degree() < f.degree() {
+				q, _ := f.divMod(g)
+				split(g)
+				split(q)
+				return
+			}
+		}
+	}
+	split(f)
+	return roots, true
+}
@@ -1,8 +1,25 @@
 package quack
 
+import (
+	"errors"
+)
+
+// Errors returned by DecodeMissing.
+var (
+	ErrNotSubset         = errors.New("quack: subtracted set is not a subset")
+	ErrThresholdExceeded = errors.New("quack: difference exceeds threshold")
+	ErrChecksum          = errors.New("quack: checksum mismatch")
+)
+
 type Sketch struct {
 	PowerSums []ModUint32
 	Count     uint32
+	// Checksum is the keyed checksum of the symbols if ChecksumKey is not
+	// nil. See type ChecksumKey.
+	Checksum    uint64
+	ChecksumKey *ChecksumKey
+	// notSubset is set by Subtract if the subtracted set is larger.
+	notSubset bool
 }
 
 func NewSketch(d int) Sketch {
@@ -23,17 +40,33 @@ func (s *Sketch) AddSymbol(t HashType) {
 	}
 	s.PowerSums[size - 1].AddAssign(y)
 	s.Count += 1
+	if s.ChecksumKey != nil {
+		s.Checksum += s.ChecksumKey.hash(t)
+	}
 }
 
 // Subtract subtracts s2 from s by modifying s in place. s and s2 must be of
 // equal length. If s is a sketch of set S and s2 is a sketch of set S2, then
-// the result is a sketch of the symmetric difference between S and S2.
+// the result is a sketch of the symmetric difference between S and S2. s and
+// s2 must either both have no checksum, or have checksums with the same key.
+//
+// The result is only decodable if S2 is a subset of S. If S2 is larger than
+// S, Count wraps around, and Subtract records so, in which case DecodeMissing
+// reports ErrNotSubset.
 func (s *Sketch) Subtract(s2 Sketch) {
 	if len(s.PowerSums) != len(s2.PowerSums) {
 		panic("subtracting sketches of different sizes")
 	}
+	if (s.ChecksumKey == nil) != (s2.ChecksumKey == nil) ||
+		(s.ChecksumKey != nil && *s.ChecksumKey != *s2.ChecksumKey) {
+		panic("subtracting sketches with different checksum keys")
+	}
 
+	if s2.Count > s.Count {
+		s.notSubset = true
+	}
 	s.Count -= s2.Count
+	s.Checksum -= s2.Checksum
 	for i := range s.PowerSums {
 		s.PowerSums[i].SubAssign(s2.PowerSums[i])
 	}
@@ -49,11 +82,24 @@ func (s Sketch) ToCoeffs() []ModUint32 {
 			coeffs[i] = coeffs[i].Sub(s.PowerSums[j].Mul(coeffs[i - j - 1]))
 		}
 		coeffs[i].SubAssign(s.PowerSums[i])
-		coeffs[i].MulAssign(InverseTableUint32[i])
+		coeffs[i].MulAssign(inverseUint32(i))
 	}
 	return coeffs
 }
 
+// newtonPowerSum returns the k-th power sum, counting from 0, of the set
+// whose polynomial has coefficients coeffs (as returned by ToCoeffs), given
+// the preceding power sums in sums, for k at least len(coeffs). By Newton's
+// identities, with n = len(coeffs),
+//   p_k + c_1 p_(k-1) + ... + c_n p_(k-n) = 0.
+func newtonPowerSum(coeffs []ModUint32, sums []ModUint32, k int) ModUint32 {
+	var p ModUint32
+	for i, c := range coeffs {
+		p.SubAssign(c.Mul(sums[k-i-1]))
+	}
+	return p
+}
+
 func EvalCoeffs(coeffs []ModUint32, x ModUint32) ModUint32 {
 	size := len(coeffs)
 	result := x
@@ -72,22 +118,67 @@ func EvalCoeffs(coeffs []ModUint32, x ModUint32) ModUint32 {
 // When successful, indicated by succ being true, fwd contains all source
 // symbols in S in case 1, or S \ S2 in case 2 (\ is the set subtraction
 // operation). rev is empty in case 1, or S2 \ S in case 2.
+//
+// If s has a checksum, Decode also fails when the missing symbols found in
+// log do not add up to it, e.g., when log lacks some of them, or when a symbol
+// in log is a root by accident. See type ChecksumKey.
+//
+// Decode is DecodeMissing, with the error reduced to a bool.
 func (s Sketch) Decode(log []HashType) (missing []HashType, succ bool) {
-	if s.Count == 0 {
-		return []HashType{}, true
+	missing, err := s.DecodeMissing(log)
+	return missing, err == nil
+}
+
+// DecodeMissing is Decode, except that it reports why it fails:
+//   - ErrNotSubset if s is the result of s.Subtract(s2), but S2 is not a
+//     subset of S, i.e., the receiver has symbols the sender never logged.
+//     Decode detects so if S2 is larger than S, as recorded by Subtract, or
+//     if the power sums of s beyond the first Count are inconsistent with
+//     the first Count. When Count equals the threshold, there are no such
+//     power sums, and Decode instead requires that at least Count symbols in
+//     log, counted with multiplicity, are roots, since S \ S2 is part of the
+//     log when S2 is a subset of S.
+//   - ErrThresholdExceeded if Count is larger than the threshold.
+//   - ErrChecksum if s has a checksum, and the missing symbols do not add up
+//     to it.
+func (s Sketch) DecodeMissing(log []HashType) (missing []HashType, err error) {
+	if s.notSubset {
+		return []HashType{}, ErrNotSubset
+	}
+	if s.Count > uint32(len(s.PowerSums)) {
+		return []HashType{}, ErrThresholdExceeded
 	}
-	if int(s.Count) > len(s.PowerSums) {
-		// panic("number of elements must not exceed threshold")
-		return []HashType{}, false
+	if s.Count == 0 {
+		// the power sums of the empty set are all 0
+		for _, p := range s.PowerSums {
+			if p != 0 {
+				return []HashType{}, ErrNotSubset
+			}
+		}
+		if !s.VerifyChecksum(nil) {
+			return []HashType{}, ErrChecksum
+		}
+		return []HashType{}, nil
 	}
 
-	missing = []HashType{}
 	coeffs := s.ToCoeffs()
+	for k := int(s.Count); k < len(s.PowerSums); k++ {
+		if newtonPowerSum(coeffs, s.PowerSums, k) != s.PowerSums[k] {
+			return []HashType{}, ErrNotSubset
+		}
+	}
+	missing = []HashType{}
 	for _, x := range log {
 		if EvalCoeffs(coeffs, NewModUint32(x)) == 0 {
 			missing = append(missing, x)
 		}
 	}
+	if int(s.Count) == len(s.PowerSums) && len(missing) < int(s.Count) {
+		return []HashType{}, ErrNotSubset
+	}
+	if !s.VerifyChecksum(missing) {
+		return missing, ErrChecksum
+	}
 
-	return missing, true
+	return missing, nil
 }
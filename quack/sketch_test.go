@@ -2,7 +2,9 @@ package quack
 
 import (
 	"testing"
+	"errors"
 	"math/rand"
+	"slices"
 )
 
 func BenchmarkQuackEncode(b *testing.B) {
@@ -286,3 +288,88 @@ func TestFixedEncodeAndDecode(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeDuplicateLog(t *testing.T) {
+	// The log is a multiset: a symbol logged twice and received once is
+	// missing once, and found at both of its positions in the log.
+	log := []HashType{1, 2, 3, 2}
+	for _, d := range []int{1, 10} {
+		InitInverseTableUint32(d)
+		s := NewSketch(d)
+		for _, x := range log {
+			s.AddSymbol(x)
+		}
+		s2 := NewSketch(d)
+		for _, x := range log[:3] {
+			s2.AddSymbol(x)
+		}
+		s.Subtract(s2)
+		missing, err := s.DecodeMissing(log)
+		if err != nil || !slices.Equal(missing, []HashType{2, 2}) {
+			t.Errorf("d=%d: decoded %v %v", d, missing, err)
+		}
+	}
+}
+
+func TestDecodeNotSubset(t *testing.T) {
+	d := 10
+	InitInverseTableUint32(d)
+	newSketch := func(symbols ...HashType) Sketch {
+		s := NewSketch(d)
+		for _, x := range symbols {
+			s.AddSymbol(x)
+		}
+		return s
+	}
+	log := []HashType{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13}
+	cases := []struct {
+		name  string
+		s, s2 Sketch
+		err   error
+	}{
+		{"subset", newSketch(1, 2, 3, 4), newSketch(2, 4), nil},
+		{"larger", newSketch(1, 2), newSketch(1, 2, 3), ErrNotSubset},
+		{"same size", newSketch(1, 2, 3), newSketch(1, 2, 4), ErrNotSubset},
+		{"one extra", newSketch(1, 2, 3, 4, 5, 6), newSketch(1, 7), ErrNotSubset},
+		{"threshold", newSketch(log...), newSketch(), ErrThresholdExceeded},
+	}
+	for _, tc := range cases {
+		tc.s.Subtract(tc.s2)
+		missing, err := tc.s.DecodeMissing(log)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: error is %v, expected %v", tc.name, err, tc.err)
+		}
+		if _, succ := tc.s.Decode(log); succ != (tc.err == nil) {
+			t.Errorf("%s: Decode returned succ=%t", tc.name, succ)
+		}
+		if tc.err == nil && !slices.Equal(missing, []HashType{1, 3}) {
+			t.Errorf("%s: decoded %v", tc.name, missing)
+		}
+	}
+
+	// Count at the threshold leaves no power sums to check for consistency.
+	s := NewSketch(3)
+	for x := HashType(1); x <= 5; x++ {
+		s.AddSymbol(x)
+	}
+	s2 := NewSketch(3)
+	s2.AddSymbol(1)
+	s2.AddSymbol(9)
+	s.Subtract(s2)
+	if _, err := s.DecodeMissing(log); err != ErrNotSubset {
+		t.Errorf("at threshold: error is %v, expected %v", err, ErrNotSubset)
+	}
+
+	// a count that does not fit in an int32 without subtraction
+	s = Sketch{PowerSums: make([]ModUint32, d), Count: 1 << 31}
+	if _, err := s.DecodeMissing(log); err != ErrThresholdExceeded {
+		t.Errorf("large count: error is %v, expected %v", err, ErrThresholdExceeded)
+	}
+
+	s = NewSketchWithChecksum(d, ChecksumKey{1})
+	s.AddSymbol(1)
+	s.AddSymbol(2)
+	if _, err := s.DecodeMissing([]HashType{1}); err != ErrChecksum {
+		t.Errorf("error is %v, expected %v", err, ErrChecksum)
+	}
+}
@@ -0,0 +1,139 @@
+package quack
+
+// powerStream produces the power sums of a multiset of symbols one at a time.
+type powerStream struct {
+	symbols []HashType
+	powers  []ModUint32 // next power of each symbol
+	next    int         // index of the next power sum
+}
+
+func (p *powerStream) addSymbol(t HashType) {
+	if p.next != 0 {
+		panic("adding symbol after producing power sums")
+	}
+	p.symbols = append(p.symbols, t)
+	p.powers = append(p.powers, NewModUint32(t))
+}
+
+// nextPowerSum returns the next power sum, i.e., the sum of x^(i+1) over the
+// symbols x for the i-th call.
+func (p *powerStream) nextPowerSum() ModUint32 {
+	var sum ModUint32
+	for i, t := range p.symbols {
+		sum.AddAssign(p.powers[i])
+		p.powers[i].MulAssign(NewModUint32(t))
+	}
+	p.next += 1
+	return sum
+}
+
+// StreamEncoder produces the power sums of a set one at a time, so that the
+// sender does not need to know the size of the difference in advance. The
+// first k power sums are the PowerSums of a Sketch with threshold k. The
+// receiver feeds them to a StreamDecoder until it decodes.
+//
+// Producing each power sum costs time linear to the size of the set.
+type StreamEncoder struct {
+	stream powerStream
+}
+
+// AddSymbol inserts source symbol t to the set. It must not be called after
+// ProduceNextPowerSum.
+func (e *StreamEncoder) AddSymbol(t HashType) {
+	e.stream.addSymbol(t)
+}
+
+// Count returns the number of symbols in the set. The receiver needs it to
+// create its StreamDecoder.
+func (e *StreamEncoder) Count() uint32 {
+	return uint32(len(e.stream.symbols))
+}
+
+// ProduceNextPowerSum returns the next power sum.
+func (e *StreamEncoder) ProduceNextPowerSum() ModUint32 {
+	return e.stream.nextPowerSum()
+}
+
+// ProducePowerSums returns the next n power sums.
+func (e *StreamEncoder) ProducePowerSums(n int) []ModUint32 {
+	sums := make([]ModUint32, n)
+	for i := range sums {
+		sums[i] = e.stream.nextPowerSum()
+	}
+	return sums
+}
+
+// StreamDecoder decodes the power sums produced by a StreamEncoder against
+// the log of the receiver, a superset of the set of the sender. It can
+// decode as soon as it has received as many power sums as there are missing
+// symbols, i.e., symbols in the log but not in the set of the sender.
+type StreamDecoder struct {
+	local   powerStream
+	diff    Sketch
+	remote  uint32
+	missing []HashType
+	decoded bool
+}
+
+// NewStreamDecoder returns a StreamDecoder for a sender whose set has count
+// symbols, as returned by StreamEncoder.Count.
+func NewStreamDecoder(count uint32) *StreamDecoder {
+	return &StreamDecoder{remote: count}
+}
+
+// AddSymbol inserts symbol t to the log. It must not be called after
+// AddPowerSum.
+func (d *StreamDecoder) AddSymbol(t HashType) {
+	d.local.addSymbol(t)
+}
+
+// AddPowerSum adds the next power sum produced by the StreamEncoder.
+func (d *StreamDecoder) AddPowerSum(p ModUint32) {
+	d.diff.PowerSums = append(d.diff.PowerSums, d.local.nextPowerSum().Sub(p))
+}
+
+// AddPowerSums adds the next power sums produced by the StreamEncoder.
+func (d *StreamDecoder) AddPowerSums(ps []ModUint32) {
+	for _, p := range ps {
+		d.AddPowerSum(p)
+	}
+}
+
+// Received returns the number of power sums received so far.
+func (d *StreamDecoder) Received() int {
+	return len(d.diff.PowerSums)
+}
+
+// Needed returns the number of power sums needed to decode, i.e., the
+// number of missing symbols.
+func (d *StreamDecoder) Needed() int {
+	return len(d.local.symbols) - int(d.remote)
+}
+
+// TryDecode tries to decode the power sums received so far, and reports
+// whether it succeeds. It is cheap to call before Received reaches Needed,
+// and does not need InverseTableUint32 to be initialized. Once it succeeds,
+// Missing returns the missing symbols.
+func (d *StreamDecoder) TryDecode() bool {
+	if d.decoded {
+		return true
+	}
+	if d.Needed() < 0 || d.Needed() > d.Received() {
+		return false
+	}
+	d.diff.Count = uint32(d.Needed())
+	missing, err := d.diff.DecodeMissing(d.local.symbols)
+	d.missing, d.decoded = missing, err == nil
+	return d.decoded
+}
+
+// Decoded returns true if and only if a call to TryDecode has succeeded.
+func (d *StreamDecoder) Decoded() bool {
+	return d.decoded
+}
+
+// Missing returns the symbols in the log but not in the set of the sender.
+// It is only valid once Decoded returns true.
+func (d *StreamDecoder) Missing() []HashType {
+	return d.missing
+}
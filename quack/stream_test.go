@@ -0,0 +1,80 @@
+package quack
+
+import (
+	"testing"
+)
+
+func TestStreamEncoderMatchesSketch(t *testing.T) {
+	d := 20
+	enc := StreamEncoder{}
+	s := NewSketch(d)
+	for _, x := range []HashType{3616712547, 2333013068, 2234311686, 4294967295} {
+		enc.AddSymbol(x)
+		s.AddSymbol(x)
+	}
+	sums := enc.ProducePowerSums(d / 2)
+	for i := d / 2; i < d; i++ {
+		sums = append(sums, enc.ProduceNextPowerSum())
+	}
+	for i := range sums {
+		if sums[i] != s.PowerSums[i] {
+			t.Fatalf("power sum %d is %d, expected %d", i, sums[i], s.PowerSums[i])
+		}
+	}
+	if enc.Count() != s.Count {
+		t.Errorf("count is %d, expected %d", enc.Count(), s.Count)
+	}
+}
+
+func TestStreamEncodeAndDecode(t *testing.T) {
+	// no entries in the inverse table, so TryDecode computes the inverses
+	InverseTableUint32 = nil
+	for _, d := range []int{0, 1, 10, 100} {
+		n := 100
+		var nextId uint32
+		enc := StreamEncoder{}
+		for i := 0; i < n; i++ {
+			nextId += 1
+			enc.AddSymbol(nextId)
+		}
+		dec := NewStreamDecoder(enc.Count())
+		for i := 1; i <= n+d; i++ {
+			dec.AddSymbol(HashType(i))
+		}
+		if dec.Needed() != d {
+			t.Errorf("d=%d: Needed is %d", d, dec.Needed())
+		}
+		for !dec.TryDecode() {
+			if dec.Received() > d {
+				t.Fatalf("d=%d: not decoded after %d power sums", d, dec.Received())
+			}
+			dec.AddPowerSum(enc.ProduceNextPowerSum())
+		}
+		if dec.Received() != d {
+			t.Errorf("d=%d: decoded after %d power sums", d, dec.Received())
+		}
+		missing := dec.Missing()
+		if len(missing) != d {
+			t.Fatalf("d=%d: %d missing symbols", d, len(missing))
+		}
+		for i, x := range missing {
+			if x != HashType(n+1+i) {
+				t.Errorf("d=%d: missing symbol %d is %d", d, i, x)
+			}
+		}
+	}
+}
+
+func TestStreamNotSubset(t *testing.T) {
+	enc := StreamEncoder{}
+	enc.AddSymbol(1)
+	enc.AddSymbol(2)
+	dec := NewStreamDecoder(enc.Count())
+	dec.AddSymbol(1)
+	dec.AddSymbol(3)
+	dec.AddSymbol(4)
+	dec.AddPowerSums(enc.ProducePowerSums(4))
+	if dec.TryDecode() {
+		t.Errorf("decoded %v from a set that is not a subset", dec.Missing())
+	}
+}
@@ -34,6 +34,15 @@ func InitInverseTableUint32(d int) {
 	}
 }
 
+// inverseUint32 returns the inverse of i+1, from InverseTableUint32 if it is
+// large enough.
+func inverseUint32(i int) ModUint32 {
+	if i < len(InverseTableUint32) {
+		return InverseTableUint32[i]
+	}
+	return ModUint32(i + 1).Inv()
+}
+
 func NewModUint32(n uint32) ModUint32 {
 	if n > ModulusUint32Small {
 		return ModUint32(n - ModulusUint32Small)
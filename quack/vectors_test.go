@@ -0,0 +1,146 @@
+package quack
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"testing"
+)
+
+//go:generate go test -run TestVectors -update
+
+var update = flag.Bool("update", false, "regenerate testdata/vectors.json")
+
+const vectorsFile = "testdata/vectors.json"
+
+// vectorFile is the layout of testdata/vectors.json. It is meant to be read
+// by implementations in other languages, so it only uses plain JSON numbers,
+// which are all below 2^32.
+type vectorFile struct {
+	Description string   `json:"description"`
+	Modulus     uint32   `json:"modulus"`
+	Vectors     []vector `json:"vectors"`
+}
+
+// vector is a test vector. The sketch of Symbols with the given Threshold,
+// minus the sketch of Subtracted, has the given PowerSums and Count. If
+// Missing is not nil, decoding the sketch with Symbols as the log returns
+// Missing.
+type vector struct {
+	Name       string      `json:"name"`
+	Threshold  int         `json:"threshold"`
+	Symbols    []HashType  `json:"symbols"`
+	Subtracted []HashType  `json:"subtracted,omitempty"`
+	PowerSums  []ModUint32 `json:"power_sums"`
+	Count      uint32      `json:"count"`
+	Missing    []HashType  `json:"missing,omitempty"`
+}
+
+// vectorSymbols returns n pseudorandom source symbols generated with
+// SplitMix64 from seed, so that the vectors are reproducible.
+func vectorSymbols(seed uint64, n int) []HashType {
+	s := make([]HashType, n)
+	for i := range s {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		z ^= z >> 31
+		s[i] = HashType(z)
+	}
+	return s
+}
+
+// newVector fills in the outputs of a vector from its inputs.
+func newVector(name string, threshold int, symbols, subtracted []HashType, decode bool) vector {
+	s := NewSketch(threshold)
+	for _, x := range symbols {
+		s.AddSymbol(x)
+	}
+	s2 := NewSketch(threshold)
+	for _, x := range subtracted {
+		s2.AddSymbol(x)
+	}
+	s.Subtract(s2)
+	v := vector{
+		Name:       name,
+		Threshold:  threshold,
+		Symbols:    symbols,
+		Subtracted: subtracted,
+		PowerSums:  s.PowerSums,
+		Count:      s.Count,
+	}
+	if decode {
+		InitInverseTableUint32(threshold)
+		missing, succ := s.Decode(symbols)
+		if !succ {
+			panic("failed to decode test vector " + name)
+		}
+		v.Missing = missing
+	}
+	return v
+}
+
+// generateVectors returns the contents of testdata/vectors.json.
+func generateVectors() []byte {
+	random := vectorSymbols(1, 1000)
+	f := vectorFile{
+		Description: "Power sum quACKs over integers modulo a prime. " +
+			"power_sums[i] is the sum of x^(i+1) modulo the prime over the " +
+			"symbols x, minus the same sum over the subtracted symbols; " +
+			"count is the number of symbols minus the number of subtracted " +
+			"symbols, modulo 2^32. Symbols are reduced modulo the prime. " +
+			"If present, missing lists the symbols, in order, that are roots " +
+			"of the polynomial whose coefficients are derived from the power sums.",
+		Modulus: ModulusUint32Small,
+		Vectors: []vector{
+			newVector("empty", 4, []HashType{}, nil, false),
+			newVector("one", 4, []HashType{1}, nil, false),
+			newVector("small", 8, []HashType{1, 2, 3, 4, 5}, nil, false),
+			newVector("large", 8, []HashType{4294967290, 4294967291, 4294967292, 4294967295}, nil, false),
+			newVector("random-20", 10, random[:20], nil, false),
+			newVector("random-1000", 20, random, nil, false),
+			newVector("subtract-3", 20, random[:100], random[3:100], true),
+			newVector("subtract-20", 20, random, random[20:], true),
+		},
+	}
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return append(b, '\n')
+}
+
+func TestVectors(t *testing.T) {
+	got := generateVectors()
+	if *update {
+		if err := os.WriteFile(vectorsFile, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(vectorsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, want) {
+		return
+	}
+	// Report the vectors that differ.
+	var gotf, wantf vectorFile
+	if err := json.Unmarshal(want, &wantf); err != nil {
+		t.Fatalf("parsing %s: %v", vectorsFile, err)
+	}
+	json.Unmarshal(got, &gotf)
+	if len(gotf.Vectors) != len(wantf.Vectors) {
+		t.Fatalf("generated %d vectors, %s has %d", len(gotf.Vectors), vectorsFile, len(wantf.Vectors))
+	}
+	for i := range gotf.Vectors {
+		g, _ := json.Marshal(gotf.Vectors[i])
+		w, _ := json.Marshal(wantf.Vectors[i])
+		if !bytes.Equal(g, w) {
+			t.Errorf("vector %s does not match %s", wantf.Vectors[i].Name, vectorsFile)
+		}
+	}
+	t.Errorf("%s does not match; run go generate if the change is intended", vectorsFile)
+}
@@ -0,0 +1,3 @@
+module github.com/ygina/subset-reconciliation/rangesync
+
+go 1.21
@@ -0,0 +1,97 @@
+package rangesync
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrInvalidMessage is returned by UnmarshalBinary when the data is corrupt.
+var ErrInvalidMessage = errors.New("rangesync: invalid message")
+
+// MarshalBinary implements encoding.BinaryMarshaler. Each Range is encoded
+// as its Lower bound and length as uvarints, its Mode, and its fingerprint
+// in 8 bytes or its number of source symbols as a uvarint followed by the
+// source symbols in 4 bytes each.
+func (m Message) MarshalBinary() ([]byte, error) {
+	b := binary.AppendUvarint(nil, uint64(len(m)))
+	for _, rg := range m {
+		b = binary.AppendUvarint(b, rg.Lower)
+		b = binary.AppendUvarint(b, rg.Upper-rg.Lower)
+		b = append(b, byte(rg.Mode))
+		switch rg.Mode {
+		case ModeFingerprint:
+			b = binary.LittleEndian.AppendUint64(b, rg.Fingerprint)
+		case ModeSymbols:
+			b = binary.AppendUvarint(b, uint64(len(rg.Symbols)))
+			for _, t := range rg.Symbols {
+				b = binary.LittleEndian.AppendUint32(b, t)
+			}
+		}
+	}
+	return b, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It checks that the
+// ranges are within the key space, and that the source symbols of each
+// Range are in it and strictly increasing.
+func (m *Message) UnmarshalBinary(data []byte) error {
+	uvarint := func() (uint64, bool) {
+		v, l := binary.Uvarint(data)
+		if l <= 0 {
+			return 0, false
+		}
+		data = data[l:]
+		return v, true
+	}
+	n, ok := uvarint()
+	if !ok || n > uint64(len(data)) {
+		return ErrInvalidMessage
+	}
+	msg := make(Message, 0, n)
+	for i := uint64(0); i < n; i++ {
+		var rg Range
+		var length uint64
+		if rg.Lower, ok = uvarint(); !ok {
+			return ErrInvalidMessage
+		}
+		if length, ok = uvarint(); !ok {
+			return ErrInvalidMessage
+		}
+		rg.Upper = rg.Lower + length
+		if rg.Lower >= KeySpace || length == 0 || length > KeySpace-rg.Lower || len(data) == 0 {
+			return ErrInvalidMessage
+		}
+		rg.Mode = Mode(data[0])
+		data = data[1:]
+		switch rg.Mode {
+		case ModeFingerprint:
+			if len(data) < 8 {
+				return ErrInvalidMessage
+			}
+			rg.Fingerprint = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case ModeSymbols:
+			count, ok := uvarint()
+			if !ok || count > uint64(len(data))/4 {
+				return ErrInvalidMessage
+			}
+			rg.Symbols = make([]HashType, count)
+			for j := range rg.Symbols {
+				t := binary.LittleEndian.Uint32(data[4*j:])
+				if uint64(t) < rg.Lower || uint64(t) >= rg.Upper || j > 0 && t <= rg.Symbols[j-1] {
+					return ErrInvalidMessage
+				}
+				rg.Symbols[j] = t
+			}
+			data = data[4*count:]
+		default:
+			return ErrInvalidMessage
+		}
+		msg = append(msg, rg)
+	}
+	if len(data) != 0 {
+		return ErrInvalidMessage
+	}
+	*m = msg
+	return nil
+}
@@ -0,0 +1,221 @@
+// Package rangesync implements range-based set reconciliation, as in
+// Negentropy, for comparison with QuACK and Rateless IBLTs.
+//
+// The two parties, the initiator and the responder, each hold a set of
+// source symbols, which are sorted. The initiator sends fingerprints of
+// ranges of the key space. For each range whose fingerprint differs from its
+// own, the receiver splits the range into smaller ranges and replies with
+// their fingerprints, or, once a range holds few symbols, with the symbols
+// themselves, which the initiator compares with its own. The parties
+// exchange messages until the initiator has nothing more to send, which takes
+// a number of round trips logarithmic to the size of the sets, but needs no
+// estimate of the size of the difference.
+package rangesync
+
+import (
+	"slices"
+	"sort"
+)
+
+type HashType = uint32
+const HashTypeSize int64 = 4
+
+// KeySpace is the upper bound of the key space, i.e., one more than the
+// largest source symbol.
+const KeySpace uint64 = 1 << 32
+
+// Mode is the content of a Range.
+type Mode byte
+
+const (
+	// ModeFingerprint means that the Range carries the fingerprint of the
+	// source symbols of the sender in the Range.
+	ModeFingerprint Mode = iota
+	// ModeSymbols means that the Range carries all the source symbols of
+	// the sender in the Range.
+	ModeSymbols
+)
+
+// Range is a range of the key space, [Lower, Upper), and the fingerprint or
+// the list of the source symbols of the sender in it.
+type Range struct {
+	Lower       uint64
+	Upper       uint64
+	Mode        Mode
+	Fingerprint uint64
+	Symbols     []HashType
+}
+
+// Message is a message of the protocol. Ranges on which the parties agree
+// are omitted, so an empty Message from the initiator ends the protocol.
+type Message []Range
+
+// DefaultBranching and DefaultSymbolLimit are the defaults of
+// Reconciler.Branching and Reconciler.SymbolLimit.
+const (
+	DefaultBranching   = 16
+	DefaultSymbolLimit = 16
+)
+
+// Reconciler is one party of the protocol.
+type Reconciler struct {
+	// Branching is the number of ranges a range with a mismatched
+	// fingerprint is split into.
+	Branching int
+	// SymbolLimit is the largest number of source symbols of a range that
+	// is sent as a list instead of as a fingerprint.
+	SymbolLimit int
+
+	set       []HashType
+	prefix    []uint64 // prefix[i] is the sum of symbolHash of set[:i]
+	initiator bool
+	have      []HashType
+	need      []HashType
+}
+
+// NewReconciler returns a Reconciler for set, with the default Branching
+// and SymbolLimit. It does not modify set.
+func NewReconciler(set []HashType) *Reconciler {
+	r := &Reconciler{
+		Branching:   DefaultBranching,
+		SymbolLimit: DefaultSymbolLimit,
+		set:         slices.Clone(set),
+	}
+	slices.Sort(r.set)
+	r.set = slices.Compact(r.set)
+	r.prefix = make([]uint64, len(r.set)+1)
+	for i, t := range r.set {
+		r.prefix[i+1] = r.prefix[i] + symbolHash(t)
+	}
+	return r
+}
+
+// symbolHash returns the 64-bit hash of source symbol t that fingerprints
+// add up, the SplitMix64 finalizer of t.
+func symbolHash(t HashType) uint64 {
+	z := uint64(t) + 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// search returns the index of the first source symbol not less than key.
+func (r *Reconciler) search(key uint64) int {
+	return sort.Search(len(r.set), func(i int) bool {
+		return uint64(r.set[i]) >= key
+	})
+}
+
+// fingerprint returns the fingerprint of set[lo:hi], which depends on both
+// the sum of the hashes of the source symbols and their number.
+func (r *Reconciler) fingerprint(lo, hi int) uint64 {
+	return symbolHash(HashType(hi-lo)) ^ (r.prefix[hi] - r.prefix[lo])
+}
+
+// appendRange appends to m the ranges describing the source symbols in
+// [lower, upper): a list if there are few, or else the fingerprints of
+// Branching ranges of about equal numbers of source symbols.
+func (r *Reconciler) appendRange(m Message, lower, upper uint64) Message {
+	lo, hi := r.search(lower), r.search(upper)
+	if hi-lo <= r.SymbolLimit {
+		return append(m, Range{
+			Lower:   lower,
+			Upper:   upper,
+			Mode:    ModeSymbols,
+			Symbols: slices.Clone(r.set[lo:hi]),
+		})
+	}
+	n := max(r.Branching, 2)
+	for j := 0; j < n; j++ {
+		l, u := lower, upper
+		if j > 0 {
+			l = uint64(r.set[lo+j*(hi-lo)/n])
+		}
+		if j < n-1 {
+			u = uint64(r.set[lo+(j+1)*(hi-lo)/n])
+		}
+		if l == u {
+			continue
+		}
+		a, b := r.search(l), r.search(u)
+		if b-a <= r.SymbolLimit {
+			m = append(m, Range{l, u, ModeSymbols, 0, slices.Clone(r.set[a:b])})
+		} else {
+			m = append(m, Range{l, u, ModeFingerprint, r.fingerprint(a, b), nil})
+		}
+	}
+	return m
+}
+
+// Initiate makes r the initiator, and returns the first Message.
+func (r *Reconciler) Initiate() Message {
+	r.initiator = true
+	r.have, r.need = nil, nil
+	return r.appendRange(Message{}, 0, KeySpace)
+}
+
+// Reconcile processes Message m from the other party, and returns the reply.
+// For the initiator, an empty reply means that the protocol is done, and
+// that Have and Need are complete; the reply is not to be sent. The
+// responder must always send the reply.
+func (r *Reconciler) Reconcile(m Message) Message {
+	reply := Message{}
+	for _, rg := range m {
+		if rg.Lower >= rg.Upper || rg.Upper > KeySpace {
+			continue
+		}
+		lo, hi := r.search(rg.Lower), r.search(rg.Upper)
+		switch rg.Mode {
+		case ModeFingerprint:
+			if r.fingerprint(lo, hi) != rg.Fingerprint {
+				reply = r.appendRange(reply, rg.Lower, rg.Upper)
+			}
+		case ModeSymbols:
+			if !r.initiator {
+				// let the initiator compare the lists
+				if !slices.Equal(r.set[lo:hi], rg.Symbols) {
+					reply = append(reply, Range{
+						Lower:   rg.Lower,
+						Upper:   rg.Upper,
+						Mode:    ModeSymbols,
+						Symbols: slices.Clone(r.set[lo:hi]),
+					})
+				}
+				continue
+			}
+			r.compare(r.set[lo:hi], rg.Symbols)
+		}
+	}
+	return reply
+}
+
+// compare records the differences between the sorted source symbols of the
+// initiator, ours, and those of the responder, theirs, in a range.
+func (r *Reconciler) compare(ours, theirs []HashType) {
+	i, j := 0, 0
+	for i < len(ours) || j < len(theirs) {
+		switch {
+		case j == len(theirs) || i < len(ours) && ours[i] < theirs[j]:
+			r.have = append(r.have, ours[i])
+			i += 1
+		case i == len(ours) || theirs[j] < ours[i]:
+			r.need = append(r.need, theirs[j])
+			j += 1
+		default:
+			i += 1
+			j += 1
+		}
+	}
+}
+
+// Have returns the source symbols found so far that the initiator has but
+// the responder lacks. It is only meaningful for the initiator.
+func (r *Reconciler) Have() []HashType {
+	return r.have
+}
+
+// Need returns the source symbols found so far that the responder has but
+// the initiator lacks. It is only meaningful for the initiator.
+func (r *Reconciler) Need() []HashType {
+	return r.need
+}
@@ -0,0 +1,172 @@
+package rangesync
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// reconcile runs the protocol between an initiator with set a and a
+// responder with set b, passing every message through MarshalBinary and
+// UnmarshalBinary, and returns the initiator, the number of round trips, and
+// the number of bytes sent.
+func reconcile(t testing.TB, a, b []HashType) (*Reconciler, int, int) {
+	init := NewReconciler(a)
+	resp := NewReconciler(b)
+	transfer := func(m Message, nbytes *int) Message {
+		data, _ := m.MarshalBinary()
+		*nbytes += len(data)
+		var got Message
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	nbytes := 0
+	rounds := 0
+	m := init.Initiate()
+	for len(m) > 0 {
+		rounds += 1
+		if rounds > 100 {
+			t.Fatal("protocol does not terminate")
+		}
+		reply := resp.Reconcile(transfer(m, &nbytes))
+		m = init.Reconcile(transfer(reply, &nbytes))
+	}
+	return init, rounds, nbytes
+}
+
+// newSets returns random sets drawn from rng with n common source symbols, na
+// only in the first, and nb only in the second, and the source symbols only in
+// each, sorted.
+func newSets(rng *rand.Rand, n, na, nb int) (a, b, onlyA, onlyB []HashType) {
+	seen := map[HashType]bool{}
+	for i := 0; i < n+na+nb; i++ {
+		x := rng.Uint32()
+		for seen[x] {
+			x = rng.Uint32()
+		}
+		seen[x] = true
+		switch {
+		case i < n:
+			a = append(a, x)
+			b = append(b, x)
+		case i < n+na:
+			a = append(a, x)
+			onlyA = append(onlyA, x)
+		default:
+			b = append(b, x)
+			onlyB = append(onlyB, x)
+		}
+	}
+	slices.Sort(onlyA)
+	slices.Sort(onlyB)
+	return
+}
+
+func TestReconcile(t *testing.T) {
+	cases := []struct{ n, na, nb int }{
+		{0, 0, 0},
+		{100, 0, 0},
+		{0, 100, 0},
+		{0, 0, 100},
+		{10000, 0, 0},
+		{10000, 1, 0},
+		{10000, 0, 1},
+		{10000, 50, 50},
+		{10000, 1000, 3000},
+		{100, 10000, 10000},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, tc := range cases {
+		a, b, onlyA, onlyB := newSets(rng, tc.n, tc.na, tc.nb)
+		r, _, _ := reconcile(t, a, b)
+		have := slices.Clone(r.Have())
+		need := slices.Clone(r.Need())
+		slices.Sort(have)
+		slices.Sort(need)
+		if !slices.Equal(have, onlyA) || !slices.Equal(need, onlyB) {
+			t.Errorf("%+v: found %d and %d differences", tc, len(have), len(need))
+		}
+	}
+}
+
+func TestReconcileBoundaries(t *testing.T) {
+	a := []HashType{0, 1, 0xfffffffe, 0xffffffff}
+	b := []HashType{0, 0xffffffff}
+	r, _, _ := reconcile(t, a, b)
+	if !slices.Equal(r.Have(), []HashType{1, 0xfffffffe}) || len(r.Need()) != 0 {
+		t.Errorf("found %v and %v", r.Have(), r.Need())
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	m := Message{
+		{0, 100, ModeSymbols, 0, []HashType{1, 2, 99}},
+		{100, KeySpace, ModeFingerprint, 12345, nil},
+	}
+	data, _ := m.MarshalBinary()
+	var got Message
+	if err := got.UnmarshalBinary(data); err != nil || len(got) != 2 ||
+		!slices.Equal(got[0].Symbols, m[0].Symbols) || got[1].Fingerprint != 12345 {
+		t.Fatalf("round trip: %v %v", got, err)
+	}
+	for i := 0; i < len(data); i++ {
+		if got.UnmarshalBinary(data[:i]) == nil {
+			t.Errorf("accepted a prefix of %d bytes", i)
+		}
+	}
+	for _, bad := range []Message{
+		{{0, 100, ModeSymbols, 0, []HashType{2, 1}}},
+		{{0, 100, ModeSymbols, 0, []HashType{100}}},
+		{{0, KeySpace + 1, ModeFingerprint, 0, nil}},
+		{{5, 5, ModeFingerprint, 0, nil}},
+		{{0, 1, Mode(2), 0, nil}},
+	} {
+		data, _ := bad.MarshalBinary()
+		if got.UnmarshalBinary(data) == nil {
+			t.Errorf("accepted %v", bad)
+		}
+	}
+}
+
+// BenchmarkRangeSync reports the number of round trips, and the bytes sent
+// in units of source symbols per difference, comparable to the symbols/diff
+// of the other packages.
+func BenchmarkRangeSync(bc *testing.B) {
+	cases := []struct {
+		name string
+		size int
+	}{
+		{"d=10", 10},
+		{"d=20", 20},
+		{"d=40", 40},
+		{"d=80", 80},
+		{"d=160", 160},
+		{"d=320", 320},
+		{"d=1000", 1000},
+		{"d=10000", 10000},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, tc := range cases {
+		bc.Run(tc.name, func(b *testing.B) {
+			d := tc.size
+			n := 10000
+			b.SetBytes(HashTypeSize * int64(d))
+			nrounds := 0
+			nbytes := 0
+			b.ResetTimer()
+			b.StopTimer()
+			for iter := 0; iter < b.N; iter++ {
+				sa, sb, _, _ := newSets(rng, n, d, 0)
+				b.StartTimer()
+				_, rounds, bytes := reconcile(b, sa, sb)
+				b.StopTimer()
+				nrounds += rounds
+				nbytes += bytes
+			}
+			b.ReportMetric(float64(nbytes)/float64(HashTypeSize)/float64(b.N*d), "symbols/diff")
+			b.ReportMetric(float64(nrounds)/float64(b.N), "rounds")
+		})
+	}
+}
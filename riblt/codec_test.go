@@ -3,6 +3,8 @@ package riblt
 import (
 	"encoding/binary"
 	"github.com/dchest/siphash"
+	"runtime"
+	"sync"
 	"testing"
 	"unsafe"
 )
@@ -147,3 +149,253 @@ func TestEncodeAndDecode(t *testing.T) {
 	}
 }
 
+
+func TestProduceCodedSymbols(t *testing.T) {
+	enc, _, _ := newTestSets(1000, 1000)
+	const n = 5000
+	ref := make([]CodedSymbol, n)
+	for i := range ref {
+		ref[i] = enc.ProduceNextCodedSymbol()
+	}
+	ranges := [][2]int{{0, 0}, {0, 1}, {0, n}, {1, 2}, {17, 4000}, {n - 1, n}, {2500, 2501}}
+	for _, r := range ranges {
+		cs := enc.ProduceCodedSymbols(r[0], r[1])
+		if len(cs) != r[1]-r[0] {
+			t.Fatalf("range [%d, %d): got %d coded symbols", r[0], r[1], len(cs))
+		}
+		for i, c := range cs {
+			if c != ref[r[0]+i] {
+				t.Errorf("range [%d, %d): coded symbol %d is %v, expected %v", r[0], r[1], r[0]+i, c, ref[r[0]+i])
+				break
+			}
+		}
+	}
+	// The sequential state is not affected.
+	if c := enc.ProduceNextCodedSymbol(); c != enc.ProduceCodedSymbols(n, n+1)[0] {
+		t.Errorf("ProduceCodedSymbols changed the sequential state")
+	}
+}
+
+func TestMultiSourceDecode(t *testing.T) {
+	// Several senders holding the same set contribute disjoint, interleaved
+	// ranges of the sequence, computed concurrently.
+	enc, dec, remote := newTestSets(1000, 1000)
+	const senders = 3
+	const block = 50
+	next := 0
+	for !dec.Decoded() || next == 0 {
+		results := make([][]CodedSymbol, senders)
+		var wg sync.WaitGroup
+		for s := 0; s < senders; s++ {
+			wg.Add(1)
+			go func(s int) {
+				defer wg.Done()
+				start := next + s*block
+				results[s] = enc.ProduceCodedSymbols(start, start+block)
+			}(s)
+		}
+		wg.Wait()
+		// deliver the ranges in reverse order
+		for s := senders - 1; s >= 0; s-- {
+			for i, c := range results[s] {
+				dec.AddCodedSymbolAt(next+s*block+i, c)
+			}
+		}
+		next += senders * block
+		dec.TryDecode()
+	}
+	checkRemote(t, dec, remote)
+}
+
+func BenchmarkProduceCodedSymbols(bc *testing.B) {
+	cases := []struct {
+		name    string
+		workers int
+	}{
+		{"workers=1", 1},
+		{"workers=2", 2},
+		{"workers=4", 4},
+		{"workers=8", 8},
+	}
+	// Each worker produces a disjoint part of the same prefix.
+	enc := Encoder{}
+	for i := 0; i < 100000; i++ {
+		enc.AddHash(newTestSymbol(uint64(i)).Hash())
+	}
+	const n = 100000
+	for _, tc := range cases {
+		bc.Run(tc.name, func(b *testing.B) {
+			for iter := 0; iter < b.N; iter++ {
+				var wg sync.WaitGroup
+				for w := 0; w < tc.workers; w++ {
+					wg.Add(1)
+					go func(w int) {
+						defer wg.Done()
+						enc.ProduceCodedSymbols(n*w/tc.workers, n*(w+1)/tc.workers)
+					}(w)
+				}
+				wg.Wait()
+			}
+		})
+	}
+}
+
+func BenchmarkEncoderMemory(bc *testing.B) {
+	cases := []struct {
+		name      string
+		scheduler Scheduler
+		size      int
+	}{
+		{"heap/n=100000", HeapScheduler, 100000},
+		{"heap/n=1000000", HeapScheduler, 1000000},
+		{"heap/n=10000000", HeapScheduler, 10000000},
+		{"calendar/n=100000", CalendarScheduler, 100000},
+		{"calendar/n=1000000", CalendarScheduler, 1000000},
+		{"calendar/n=10000000", CalendarScheduler, 10000000},
+	}
+	for _, tc := range cases {
+		bc.Run(tc.name, func(b *testing.B) {
+			var bytes uint64
+			var before, after runtime.MemStats
+			b.ResetTimer()
+			b.StopTimer()
+			for iter := 0; iter < b.N; iter++ {
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				b.StartTimer()
+				// Insert the set, and produce the first few coded
+				// symbols, to which a large fraction of it is mapped.
+				enc := &Encoder{}
+				enc.SetScheduler(tc.scheduler)
+				for i := 0; i < tc.size; i++ {
+					enc.AddHash(HashType(i) * 0x9e3779b9)
+				}
+				for i := 0; i < 100; i++ {
+					enc.ProduceNextCodedSymbol()
+				}
+				b.StopTimer()
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				bytes += after.HeapAlloc - before.HeapAlloc
+				runtime.KeepAlive(enc)
+			}
+			b.ReportMetric(float64(bytes)/float64(b.N*tc.size), "bytes/element")
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*tc.size), "ns/element")
+		})
+	}
+}
+
+func TestDecoderStats(t *testing.T) {
+	enc, dec, remote := newTestSets(100, 100)
+	if s := dec.Stats(); s.Remaining != -1 || s.Received != 0 {
+		t.Errorf("stats of an empty Decoder: %v", s)
+	}
+	// Skip coded symbol 0, so the remaining difference is unknown.
+	enc.ProduceNextCodedSymbol()
+	dec.AddCodedSymbolAt(1, enc.ProduceNextCodedSymbol())
+	if s := dec.Stats(); s.Remaining != -1 || s.Received != 1 || s.Lost != 1 {
+		t.Errorf("stats after losing coded symbol 0: %v", s)
+	}
+	enc.Reset()
+	dec.Reset()
+	for v := range remote {
+		enc.AddHash(v)
+	}
+
+	prev := len(remote)
+	for i := 0; i == 0 || !dec.Decoded(); i++ {
+		dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+		s := dec.Stats()
+		if s.Received != i+1 || s.Remaining < 0 || s.Remaining > prev {
+			t.Fatalf("stats after %d coded symbols: %v", i+1, s)
+		}
+		if s.Remote+s.Remaining != len(remote) {
+			t.Fatalf("%d recovered and %d remaining of %d: %v", s.Remote, s.Remaining, len(remote), s)
+		}
+		pending := s.Pending
+		dec.TryDecode()
+		s = dec.Stats()
+		if s.Pending != 0 || s.Decoded < pending {
+			t.Fatalf("stats after TryDecode: %v", s)
+		}
+		prev = s.Remaining
+	}
+	if s := dec.Stats(); s.Remaining != 0 || s.Remote != len(remote) || s.Decoded != s.Received {
+		t.Errorf("stats after decoding: %v", s)
+	}
+}
+
+// falseSuccessSymbol returns a source symbol that is mapped to coded symbol 3,
+// but not to coded symbols 1 or 2. A Decoder that loses coded symbol 0 and
+// receives coded symbols 1 and 2 of a set with just this source symbol
+// wrongly looks done.
+func falseSuccessSymbol() HashType {
+	for i := uint64(0); ; i++ {
+		s := newTestSymbol(i).Hash()
+		m := newMapping(nil, s)
+		if m.next(nil) == 3 {
+			return s
+		}
+	}
+}
+
+func TestConfirmation(t *testing.T) {
+	s := falseSuccessSymbol()
+	for _, k := range []int{0, 3} {
+		enc := Encoder{}
+		enc.AddHash(s)
+		dec := Decoder{}
+		dec.SetConfirmation(k)
+		enc.ProduceNextCodedSymbol()
+		for i := 1; i < 3; i++ {
+			dec.AddCodedSymbolAt(i, enc.ProduceNextCodedSymbol())
+			dec.TryDecode()
+		}
+		if dec.Decoded() != (k == 0) {
+			t.Errorf("k=%d: Decoded is %t before receiving coded symbol 3", k, dec.Decoded())
+		}
+		if k == 0 {
+			continue
+		}
+		// Coded symbol 3 reveals s, and resets the confirmations.
+		for i := 3; i < 3+k; i++ {
+			dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+			dec.TryDecode()
+			if dec.Decoded() {
+				t.Fatalf("k=%d: Decoded after %d confirmations", k, i-3)
+			}
+		}
+		dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+		dec.TryDecode()
+		if s := dec.Stats(); !dec.Decoded() || s.Confirmed != k {
+			t.Errorf("k=%d: not decoded after %d confirmations: %v", k, k, s)
+		}
+		if len(dec.Remote()) != 1 || dec.Remote()[0] != s {
+			t.Errorf("k=%d: decoded %v", k, dec.Remote())
+		}
+	}
+}
+
+func TestRemoteChecksum(t *testing.T) {
+	s := falseSuccessSymbol()
+	enc, dec, _ := newTestSets(0, 100)
+	enc.AddHash(s)
+	dec.SetRemoteChecksum(enc.Checksum())
+	enc.ProduceNextCodedSymbol()
+	for i := 1; i < 3; i++ {
+		dec.AddCodedSymbolAt(i, enc.ProduceNextCodedSymbol())
+		dec.TryDecode()
+	}
+	if dec.Decoded() {
+		t.Errorf("Decoded before receiving coded symbol 3")
+	}
+	dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+	dec.TryDecode()
+	if !dec.Decoded() {
+		t.Errorf("not decoded after receiving coded symbol 3")
+	}
+	dec.Reset()
+	if dec.hasChecksum {
+		t.Errorf("Reset kept the checksum")
+	}
+}
@@ -0,0 +1,206 @@
+package riblt
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// ServeDatagram and SyncDatagram are the counterparts of Serve and Sync for
+// unreliable transports that preserve message boundaries but may drop,
+// duplicate or reorder messages, such as a connected UDP socket. Each Write
+// on the underlying io.ReadWriter must send exactly one datagram, and each
+// Read must return exactly one datagram.
+//
+// Each datagram is a one-byte message type followed by the payload. A batch
+// of coded symbols carries the index of its first coded symbol, so that the
+// Decoder can place the coded symbols with AddCodedSymbolAt regardless of the
+// batches that were lost or reordered. There is no flow control: Alice sends
+// batches, at most one per SessionOptions.Interval, until Bob tells her to
+// stop. Because the stop message itself may be lost, Bob sends it several
+// times a few milliseconds apart, and callers should bound ServeDatagram with
+// a context deadline.
+
+const (
+	msgIndexedSymbols byte = iota + 16 // a batch of coded symbols and the index of the first
+	msgDatagramStop                    // request to stop sending coded symbols
+)
+
+const (
+	// maxDatagramSize is the largest datagram we expect to receive.
+	maxDatagramSize = 1 << 16
+	// stopRepeats is the number of times SyncDatagram sends the stop message,
+	// stopInterval apart so that a burst of loss does not drop them all.
+	stopRepeats  = 3
+	stopInterval = 5 * time.Millisecond
+	// maxDatagramGap is the largest number of coded symbols a batch may skip
+	// past the last one received for SyncDatagram to accept it, so that a
+	// single bogus index cannot make the Decoder allocate a lot of memory.
+	maxDatagramGap = 1 << 16
+)
+
+// ServeDatagram streams the coded symbols of enc over the unreliable transport
+// conn until the peer, running SyncDatagram, signals that it has decoded the
+// symmetric difference, in which case ServeDatagram returns nil. If the
+// peer's stop messages are all lost, ServeDatagram keeps sending until ctx is
+// done, and then returns ctx.Err().
+//
+// SessionOptions.BatchSize bounds the number of coded symbols per datagram.
+// The encoded size of a coded symbol is at most 14 bytes.
+func ServeDatagram(ctx context.Context, conn io.ReadWriter, enc *Encoder, opts *SessionOptions) error {
+	defer watchContext(ctx, conn)()
+
+	var stopped atomic.Bool
+	readerDone := make(chan struct{})
+	defer func() {
+		// As in Serve, do not leave the reader blocked on conn after we
+		// return because ctx is done or a write failed.
+		select {
+		case <-readerDone:
+		default:
+			if interrupt := interrupter(conn); interrupt != nil {
+				interrupt()
+				<-readerDone
+			}
+		}
+	}()
+	go func() {
+		defer close(readerDone)
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			// Anything else is either a duplicate from an earlier session or
+			// garbage, neither of which concerns us.
+			if n == 1 && buf[0] == msgDatagramStop {
+				stopped.Store(true)
+				return
+			}
+		}
+	}()
+
+	batchSize := opts.batchSize()
+	interval := opts.interval()
+	next := 0
+	batch := make([]CodedSymbol, batchSize)
+	var buf []byte
+	for !stopped.Load() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for i := range batch {
+			batch[i] = enc.ProduceNextCodedSymbol()
+		}
+		buf = appendIndexedBatch(buf[:0], next, batch)
+		next += batchSize
+		if _, err := conn.Write(buf); err != nil {
+			return sessionError(ctx, err)
+		}
+		if interval > 0 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	// The reader exits on its own after the stop message.
+	<-readerDone
+	return nil
+}
+
+// SyncDatagram receives coded symbols over the unreliable transport conn from
+// a peer running ServeDatagram, and passes them to dec until dec decodes the
+// symmetric difference. It then tells the peer to stop, and returns nil. If
+// dec finds that its local set is not a subset of the peer's, SyncDatagram
+// also tells the peer to stop, and returns ErrNotSubset. Malformed datagrams
+// are ignored, as are batches whose index is more than a fixed window past the
+// coded symbols received so far. dec must not have been passed any coded
+// symbols before.
+func SyncDatagram(ctx context.Context, conn io.ReadWriter, dec *Decoder, opts *SessionOptions) error {
+	defer watchContext(ctx, conn)()
+
+	maxSymbols := opts.maxSymbols()
+	received := 0
+	var decodeErr error
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return sessionError(ctx, err)
+		}
+		start, symbols, err := parseIndexedBatch(buf[:n])
+		if err != nil || start > len(dec.cs)+maxDatagramGap {
+			continue
+		}
+		for i, s := range symbols {
+			dec.AddCodedSymbolAt(start+i, s)
+		}
+		received += len(symbols)
+		if err := dec.TryDecode(); err != nil {
+			decodeErr = err
+			break
+		}
+		if dec.Decoded() {
+			break
+		}
+		if maxSymbols > 0 && received >= maxSymbols {
+			return ErrSymbolLimit
+		}
+	}
+
+	for i := 0; i < stopRepeats; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(stopInterval):
+			case <-ctx.Done():
+				return decodeErr
+			}
+		}
+		if _, err := conn.Write([]byte{msgDatagramStop}); err != nil {
+			return sessionError(ctx, err)
+		}
+	}
+	return decodeErr
+}
+
+// appendIndexedBatch appends a datagram carrying the coded symbols with
+// indices start, start+1, ... to b.
+func appendIndexedBatch(b []byte, start int, symbols []CodedSymbol) []byte {
+	b = append(b, msgIndexedSymbols)
+	b = binary.AppendUvarint(b, uint64(start))
+	for _, s := range symbols {
+		b = appendCodedSymbol(b, s)
+	}
+	return b
+}
+
+// parseIndexedBatch decodes a datagram encoded by appendIndexedBatch.
+func parseIndexedBatch(b []byte) (start int, symbols []CodedSymbol, err error) {
+	if len(b) == 0 || b[0] != msgIndexedSymbols {
+		return 0, nil, fmt.Errorf("%w: not a batch of coded symbols", ErrProtocol)
+	}
+	s, l := binary.Uvarint(b[1:])
+	if l <= 0 {
+		return 0, nil, fmt.Errorf("%w: malformed index", ErrProtocol)
+	}
+	b = b[1+l:]
+	for len(b) != 0 {
+		var c CodedSymbol
+		c, b, err = readCodedSymbol(b)
+		if err != nil {
+			return 0, nil, err
+		}
+		symbols = append(symbols, c)
+	}
+	if s > uint64(math.MaxInt-len(symbols)) {
+		return 0, nil, fmt.Errorf("%w: index %d out of range", ErrProtocol, s)
+	}
+	return int(s), symbols, nil
+}
@@ -0,0 +1,346 @@
+package riblt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// lossyConn is one end of an in-process datagram channel that drops and
+// reorders datagrams at random.
+type lossyConn struct {
+	in      <-chan []byte
+	out     chan<- []byte
+	closed  chan struct{}
+	once    *sync.Once
+	loss    float64 // probability that a datagram is dropped
+	reorder float64 // probability that a datagram is overtaken by the next
+
+	mu   sync.Mutex
+	rng  *rand.Rand
+	held []byte // datagram waiting to be overtaken
+}
+
+// newLossyPipe returns the two ends of a lossy datagram channel.
+func newLossyPipe(seed int64, loss, reorder float64) (*lossyConn, *lossyConn) {
+	ab := make(chan []byte, 64)
+	ba := make(chan []byte, 64)
+	closed := make(chan struct{})
+	once := &sync.Once{}
+	a := &lossyConn{in: ba, out: ab, closed: closed, once: once, loss: loss, reorder: reorder, rng: rand.New(rand.NewSource(seed))}
+	b := &lossyConn{in: ab, out: ba, closed: closed, once: once, loss: loss, reorder: reorder, rng: rand.New(rand.NewSource(seed + 1))}
+	return a, b
+}
+
+func (c *lossyConn) Read(b []byte) (int, error) {
+	select {
+	case d := <-c.in:
+		return copy(b, d), nil
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *lossyConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if c.rng.Float64() < c.loss {
+		return len(b), nil
+	}
+	d := append([]byte(nil), b...)
+	if c.held == nil && c.rng.Float64() < c.reorder {
+		c.held = d
+		return len(b), nil
+	}
+	c.send(d)
+	if c.held != nil {
+		c.send(c.held)
+		c.held = nil
+	}
+	return len(b), nil
+}
+
+// send delivers d, or drops it if the receiver is too far behind.
+func (c *lossyConn) send(d []byte) {
+	select {
+	case c.out <- d:
+	default:
+	}
+}
+
+func (c *lossyConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func TestAddCodedSymbolAt(t *testing.T) {
+	cases := []struct {
+		name string
+		loss float64
+	}{
+		{"loss=0", 0},
+		{"loss=0.1", 0.1},
+		{"loss=0.5", 0.5},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			enc, dec, remote := newTestSets(1000, 1000)
+			rng := rand.New(rand.NewSource(1))
+			// Shuffle coded symbols within blocks of 100, and drop some.
+			const block = 100
+			next := 0
+			for !dec.Decoded() || next == 0 {
+				perm := rng.Perm(block)
+				symbols := make([]CodedSymbol, block)
+				for i := range symbols {
+					symbols[i] = enc.ProduceNextCodedSymbol()
+				}
+				for _, i := range perm {
+					if rng.Float64() < tc.loss {
+						continue
+					}
+					dec.AddCodedSymbolAt(next+i, symbols[i])
+					// duplicates are harmless
+					if rng.Float64() < 0.1 {
+						dec.AddCodedSymbolAt(next+i, symbols[i])
+					}
+				}
+				next += block
+				dec.TryDecode()
+				if next > 1000000 {
+					t.Fatalf("failed to decode after %d coded symbols", next)
+				}
+			}
+			checkRemote(t, dec, remote)
+		})
+	}
+}
+
+func TestAddCodedSymbolAtMixed(t *testing.T) {
+	// Coded symbols passed by AddCodedSymbol extend the sequence after the
+	// largest index passed so far.
+	enc, dec, remote := newTestSets(100, 100)
+	for i := 0; ; i++ {
+		c := enc.ProduceNextCodedSymbol()
+		if i%2 == 0 {
+			dec.AddCodedSymbolAt(i, c)
+		} else {
+			dec.AddCodedSymbol(c)
+		}
+		dec.TryDecode()
+		if dec.Decoded() {
+			break
+		}
+	}
+	checkRemote(t, dec, remote)
+}
+
+func TestIndexedBatchEncoding(t *testing.T) {
+	symbols := []CodedSymbol{{1, 2}, {3, -4}, {0xffffffff, 0}}
+	b := appendIndexedBatch(nil, 1234567, symbols)
+	start, got, err := parseIndexedBatch(b)
+	if err != nil {
+		t.Fatalf("parseIndexedBatch: %v", err)
+	}
+	if start != 1234567 {
+		t.Errorf("decoded start index %d, expected 1234567", start)
+	}
+	if len(got) != len(symbols) {
+		t.Fatalf("decoded %d coded symbols, expected %d", len(got), len(symbols))
+	}
+	for i := range got {
+		if got[i] != symbols[i] {
+			t.Errorf("decoded %v, expected %v", got[i], symbols[i])
+		}
+	}
+	if _, _, err := parseIndexedBatch(b[:len(b)-1]); err == nil {
+		t.Errorf("truncated batch decoded without error")
+	}
+	for _, b := range malformedBatches() {
+		if _, _, err := parseIndexedBatch(b); err == nil {
+			t.Errorf("batch %x decoded without error", b)
+		}
+	}
+}
+
+// malformedBatches returns batches of coded symbols whose indices do not fit
+// in an int.
+func malformedBatches() [][]byte {
+	c := appendCodedSymbol(nil, CodedSymbol{1, 1})
+	return [][]byte{
+		append(binary.AppendUvarint([]byte{msgIndexedSymbols}, math.MaxUint64), c...),
+		append(binary.AppendUvarint([]byte{msgIndexedSymbols}, 1<<63), c...),
+		appendIndexedBatch(nil, math.MaxInt, []CodedSymbol{{1, 1}, {2, 1}}),
+	}
+}
+
+func TestAddCodedSymbolAtNegative(t *testing.T) {
+	_, dec, _ := newTestSets(0, 10)
+	dec.AddCodedSymbolAt(-1, CodedSymbol{1, 1})
+	if s := dec.Stats(); s.Received != 0 || s.Lost != 0 {
+		t.Errorf("negative index changed the Decoder: %v", s)
+	}
+}
+
+func TestDatagramSession(t *testing.T) {
+	cases := []struct {
+		name    string
+		loss    float64
+		reorder float64
+	}{
+		{"reliable", 0, 0},
+		{"loss=0.2", 0.2, 0},
+		{"reorder=0.3", 0, 0.3},
+		{"loss=0.3,reorder=0.3", 0.3, 0.3},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			enc, dec, remote := newTestSets(1000, 1000)
+			alice, bob := newLossyPipe(42, tc.loss, tc.reorder)
+			defer alice.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			opts := &SessionOptions{BatchSize: 16, Interval: 10 * time.Microsecond}
+			done := make(chan error)
+			go func() {
+				done <- ServeDatagram(ctx, alice, enc, opts)
+			}()
+			if err := SyncDatagram(ctx, bob, dec, opts); err != nil {
+				t.Fatalf("SyncDatagram: %v", err)
+			}
+			checkRemote(t, dec, remote)
+			// ServeDatagram stops once a stop message gets through. If all of
+			// them are lost, it runs until the deadline.
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Errorf("ServeDatagram: %v", err)
+				}
+			case <-time.After(time.Second):
+				cancel()
+				<-done
+			}
+		})
+	}
+}
+
+// runDatagramSession runs ServeDatagram and SyncDatagram on a reliable
+// datagram channel, after sending the given datagrams to SyncDatagram.
+func runDatagramSession(t *testing.T, enc *Encoder, dec *Decoder, garbage [][]byte) error {
+	t.Helper()
+	alice, bob := newLossyPipe(1, 0, 0)
+	defer alice.Close()
+	for _, b := range garbage {
+		alice.Write(b)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- ServeDatagram(ctx, alice, enc, nil)
+	}()
+	err := SyncDatagram(ctx, bob, dec, nil)
+	if serveErr := <-done; serveErr != nil {
+		t.Errorf("ServeDatagram: %v", serveErr)
+	}
+	return err
+}
+
+func TestDatagramSessionMalformed(t *testing.T) {
+	enc, dec, remote := newTestSets(100, 100)
+	garbage := append(malformedBatches(),
+		appendIndexedBatch(nil, maxDatagramGap+1, []CodedSymbol{{1, 1}}),
+		appendIndexedBatch(nil, 1<<40, []CodedSymbol{{1, 1}}),
+	)
+	if err := runDatagramSession(t, enc, dec, garbage); err != nil {
+		t.Fatalf("SyncDatagram: %v", err)
+	}
+	checkRemote(t, dec, remote)
+	if n := len(dec.cs); n > 10000 {
+		t.Errorf("Decoder holds %d coded symbols", n)
+	}
+}
+
+func TestDatagramSessionNotSubset(t *testing.T) {
+	enc, dec, _ := newTestSets(100, 100)
+	dec.AddSymbol(newTestSymbol(1000000).Hash())
+	if err := runDatagramSession(t, enc, dec, nil); !errors.Is(err, ErrNotSubset) {
+		t.Errorf("SyncDatagram returned %v, expected ErrNotSubset", err)
+	}
+}
+
+// deadlineConn is a datagram conn on which nothing ever arrives. Its Reads
+// block until a deadline in the past is set, and its Writes fail after a
+// given number of datagrams.
+type deadlineConn struct {
+	writes    int
+	reads     atomic.Int32 // Reads started
+	reading   atomic.Int32 // Reads in progress
+	interrupt chan struct{}
+	once      sync.Once
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	c.reads.Add(1)
+	c.reading.Add(1)
+	defer c.reading.Add(-1)
+	<-c.interrupt
+	return 0, os.ErrDeadlineExceeded
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if c.writes == 0 {
+		return 0, net.ErrClosed
+	}
+	c.writes -= 1
+	return len(b), nil
+}
+
+func (c *deadlineConn) SetDeadline(t time.Time) error {
+	if !t.IsZero() && t.Before(time.Now()) {
+		c.once.Do(func() { close(c.interrupt) })
+	}
+	return nil
+}
+
+func TestServeDatagramStopsReader(t *testing.T) {
+	cases := []struct {
+		name   string
+		writes int
+		err    error
+	}{
+		{"write error", 3, net.ErrClosed},
+		{"cancel", math.MaxInt, context.DeadlineExceeded},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			enc, _, _ := newTestSets(100, 0)
+			conn := &deadlineConn{writes: tc.writes, interrupt: make(chan struct{})}
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			opts := &SessionOptions{Interval: time.Millisecond}
+			if err := ServeDatagram(ctx, conn, enc, opts); !errors.Is(err, tc.err) {
+				t.Errorf("ServeDatagram returned %v, expected %v", err, tc.err)
+			}
+			// The reader must have returned from its Read, rather than be
+			// left to consume datagrams meant for the caller.
+			if conn.reads.Load() == 0 || conn.reading.Load() != 0 {
+				t.Errorf("reader is still running")
+			}
+		})
+	}
+}
@@ -1,5 +1,15 @@
 package riblt
 
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotSubset is returned by TryDecode when it finds a source symbol that is
+// exclusive to B, the Decoder's local set. The Decoder only handles the case
+// where B is a subset of A.
+var ErrNotSubset = errors.New("riblt: local set is not a subset of remote set")
+
 // Decoder computes the symmetric difference between two sets A, B. The Decoder
 // knows B (the local set) and expects coded symbols for A (the remote set). 
 type Decoder struct {
@@ -16,12 +26,51 @@ type Decoder struct {
 	decodable []int
 	// number of coded symbols that are decoded
 	decoded int
+	// lost[i] is true if and only if coded symbol i has not been received,
+	// but a coded symbol of a larger index has; nil if no symbol is lost
+	lost []bool
+	// number of true entries in lost
+	nlost int
+	// number of the most recently received coded symbols that were empty on
+	// arrival
+	streak int
+	// number of such coded symbols required by Decoded
+	confirmations int
+	// checksum of A set by SetRemoteChecksum, if hasChecksum
+	checksum    uint64
+	hasChecksum bool
 }
 
 // Decoded returns true if and only if every existing coded symbols d received
-// so far have been decoded.
+// so far have been decoded, and the confirmation policy set by
+// SetConfirmation and SetRemoteChecksum is satisfied.
 func (d *Decoder) Decoded() bool {
-	return d.decoded == len(d.cs)
+	if d.decoded != len(d.cs)-d.nlost || d.streak < d.confirmations {
+		return false
+	}
+	return !d.hasChecksum || d.window.sum-d.local.sum+d.remote.sum == d.checksum
+}
+
+// SetConfirmation makes Decoded additionally require that the last k coded
+// symbols received were empty on arrival, i.e., that the source symbols known
+// at the time accounted for all of their contents. Without it, Decoded may
+// report success by coincidence, e.g., when coded symbol 0 is lost and the
+// few received after it happen to contain no unknown source symbol. A source
+// symbol that is yet unknown is mapped to coded symbol i with probability
+// about 1/(1+i/2), so each confirmation after i coded symbols catches it with
+// that probability. Zero, the default, disables confirmation.
+func (d *Decoder) SetConfirmation(k int) {
+	d.confirmations = k
+}
+
+// SetRemoteChecksum makes Decoded additionally require that the source
+// symbols recovered for A, i.e., those of B not in Local plus those in Remote,
+// have checksum c, which must be the Checksum of the remote Encoder. A
+// reconciliation that wrongly looks complete then passes with probability
+// about 2^-64, unless distinct source symbols have equal hashes.
+func (d *Decoder) SetRemoteChecksum(c uint64) {
+	d.checksum = c
+	d.hasChecksum = true
 }
 
 // Local returns the list of source symbols that are present in B but not in A.
@@ -34,6 +83,93 @@ func (d *Decoder) Remote() []HashType {
 	return d.remote.symbols
 }
 
+// DecoderStats describes the progress of a Decoder. See Decoder.Stats.
+type DecoderStats struct {
+	// Received is the number of coded symbols received.
+	Received int
+	// Lost is the number of coded symbols skipped by AddCodedSymbolAt that
+	// have not been received since.
+	Lost int
+	// Decoded is the number of received coded symbols that are fully peeled,
+	// i.e., whose source symbols are all recovered.
+	Decoded int
+	// Pending is the number of coded symbols that TryDecode will peel next,
+	// i.e., that became pure since the last call to TryDecode.
+	Pending int
+	// Remote and Local are the numbers of source symbols recovered so far
+	// that are exclusive to A and B, respectively.
+	Remote int
+	Local  int
+	// Confirmed is the number of the most recently received coded symbols
+	// that were empty on arrival. See SetConfirmation.
+	Confirmed int
+	// Remaining estimates the number of source symbols in the symmetric
+	// difference not recovered yet. It is exact when B is a subset of A, and
+	// a lower bound otherwise. It is -1 if unknown, i.e., before coded symbol
+	// 0 is received.
+	Remaining int
+}
+
+// String formats s for logging.
+func (s DecoderStats) String() string {
+	return fmt.Sprintf("received=%d lost=%d decoded=%d pending=%d remote=%d local=%d confirmed=%d remaining=%d",
+		s.Received, s.Lost, s.Decoded, s.Pending, s.Remote, s.Local, s.Confirmed, s.Remaining)
+}
+
+// Stats returns the progress of d. It takes constant time.
+func (d *Decoder) Stats() DecoderStats {
+	s := DecoderStats{
+		Received:  len(d.cs) - d.nlost,
+		Lost:      d.nlost,
+		Decoded:   d.decoded,
+		Pending:   len(d.decodable),
+		Remote:    len(d.remote.symbols),
+		Local:     len(d.local.symbols),
+		Confirmed: d.streak,
+		Remaining: -1,
+	}
+	// Every source symbol is mapped to coded symbol 0, so once the known
+	// source symbols are peeled off, its count is the number of those left
+	// in A minus those left in B.
+	if len(d.cs) != 0 && (d.lost == nil || !d.lost[0]) {
+		s.Remaining = int(max(d.cs[0].Count, -d.cs[0].Count))
+	}
+	return s
+}
+
+// SetMapping makes d map source symbols to coded symbols using mp, which must
+// be the Mapping of the remote Encoder. See type Mapping. A nil mp selects the
+// built-in mapping. SetMapping may be called before or after source symbols
+// are added, but it panics if called after AddCodedSymbol.
+func (d *Decoder) SetMapping(mp Mapping) {
+	if len(d.cs) != 0 {
+		panic("setting mapping after receiving coded symbols")
+	}
+	d.window.setMapping(mp)
+	d.local.setMapping(mp)
+	d.remote.setMapping(mp)
+}
+
+// SetKey makes d map source symbols to coded symbols using Key k, which must be
+// the Key of the remote Encoder. See type Key. It is equivalent to
+// SetMapping(k).
+func (d *Decoder) SetKey(k Key) {
+	d.SetMapping(k)
+}
+
+// SetScheduler makes d use Scheduler s. See type Scheduler. It may differ
+// from the Scheduler of the remote Encoder. SetScheduler may be called before
+// or after source symbols are added, but it panics if called after
+// AddCodedSymbol.
+func (d *Decoder) SetScheduler(s Scheduler) {
+	if len(d.cs) != 0 {
+		panic("setting scheduler after receiving coded symbols")
+	}
+	d.window.setScheduler(s)
+	d.local.setScheduler(s)
+	d.remote.setScheduler(s)
+}
+
 // AddSymbol adds a source symbol to B, the Decoder's local set. It is
 // undefined behavior to call AddSymbol after AddCodedSymbol has been called
 // one or multiple times.
@@ -56,19 +192,86 @@ func (d *Decoder) AddCodedSymbol(c CodedSymbol) {
 	c = d.window.applyWindow(c, remove)
 	c = d.remote.applyWindow(c, remove)
 	c = d.local.applyWindow(c, add)
+	d.confirm(c)
 	// insert the new coded symbol
 	d.cs = append(d.cs, c)
-	// check if the coded symbol is decodable, and insert into decodable list if so
+	if d.lost != nil {
+		d.lost = append(d.lost, false)
+	}
+	d.checkDecodable(len(d.cs) - 1)
+	return
+}
+
+// AddCodedSymbolAt passes the coded symbol of index i in A's sequence to the
+// Decoder. Unlike AddCodedSymbol, coded symbols may be passed in any order,
+// and some may never be passed at all, e.g., because they were lost in
+// transit. Coded symbols that have not been passed are treated as unknown,
+// and decoding relies on the ones that have. Passing the same index twice has
+// no effect. Note that a source symbol is only mapped to a number of coded
+// symbols logarithmic to the length of the sequence, so the number of coded
+// symbols required grows quickly with the fraction that are lost. A negative
+// index has no effect either. Passing index i costs memory for i coded
+// symbols, so callers should bound i when it comes from an untrusted peer.
+func (d *Decoder) AddCodedSymbolAt(i int, c CodedSymbol) {
+	if i < 0 {
+		return
+	}
+	if i >= len(d.cs) {
+		// Mark the coded symbols between the last one received and this one
+		// as lost. We still peel the source symbols we know off them, so that
+		// they are up to date when they arrive.
+		for len(d.cs) < i {
+			l := d.window.applyWindow(CodedSymbol{}, remove)
+			l = d.remote.applyWindow(l, remove)
+			l = d.local.applyWindow(l, add)
+			d.cs = append(d.cs, l)
+			if d.lost == nil {
+				d.lost = make([]bool, len(d.cs)-1, cap(d.cs))
+			}
+			d.lost = append(d.lost, true)
+			d.nlost += 1
+		}
+		d.AddCodedSymbol(c)
+		return
+	}
+	if d.lost == nil || !d.lost[i] {
+		return
+	}
+	// Peeling is linear, so we can combine the received coded symbol with
+	// whatever has been peeled off its index so far.
+	d.cs[i].Hash ^= c.Hash
+	d.cs[i].Count += c.Count
+	d.confirm(d.cs[i])
+	d.lost[i] = false
+	d.nlost -= 1
+	d.checkDecodable(i)
+}
+
+// confirm updates the number of coded symbols in a row that were empty on
+// arrival, given c, a coded symbol that just arrived, with the known source
+// symbols peeled off.
+func (d *Decoder) confirm(c CodedSymbol) {
+	if c == (CodedSymbol{}) {
+		d.streak += 1
+	} else {
+		d.streak = 0
+	}
+}
+
+// checkDecodable inserts coded symbol cidx into the decodable list if it is
+// decodable. It must be called exactly once for each coded symbol, when the
+// coded symbol is received.
+func (d *Decoder) checkDecodable(cidx int) {
+	c := d.cs[cidx]
 	if c.Count == 1 || c.Count == -1 {
-		d.decodable = append(d.decodable, len(d.cs)-1)
+		d.decodable = append(d.decodable, cidx)
 	} else if c.Count == 0 && c.Hash == 0 {
-		d.decodable = append(d.decodable, len(d.cs)-1)
+		d.decodable = append(d.decodable, cidx)
 	}
-	return
 }
 
 func (d *Decoder) applyNewSymbol(t HashType, direction int64) randomMapping {
-	m := randomMapping{t, 0}
+	m := newMapping(d.window.mapping, t)
 	for int(m.lastIdx) < len(d.cs) {
 		cidx := int(m.lastIdx)
 		d.cs[cidx] = d.cs[cidx].apply(t, direction)
@@ -91,16 +294,24 @@ func (d *Decoder) applyNewSymbol(t HashType, direction int64) randomMapping {
 		// duplicates. On the other hand, it is fine that we insert all
 		// degree-1 or -1 decodable symbols, because we only see them in such
 		// state once.
-		if d.cs[cidx].Count == -1 || d.cs[cidx].Count == 1 {
+		//
+		// Lost coded symbols are never decodable. They are checked once they
+		// are received.
+		lost := d.lost != nil && d.lost[cidx]
+		if !lost && (d.cs[cidx].Count == -1 || d.cs[cidx].Count == 1) {
 			d.decodable = append(d.decodable, cidx)
 		}
-		m.nextIndex()
+		m.next(d.window.mapping)
 	}
 	return m
 }
 
-// TryDecode tries to decode all coded symbols received so far.
-func (d *Decoder) TryDecode() {
+// TryDecode tries to decode all coded symbols received so far. It returns
+// ErrNotSubset, and stops decoding, if it finds a coded symbol of negative
+// degree, which proves that B is not a subset of A. The Decoder then stays in
+// that state: later calls return ErrNotSubset again, and Decoded returns
+// false.
+func (d *Decoder) TryDecode() error {
 	for didx := 0; didx < len(d.decodable); didx += 1 {
 		cidx := d.decodable[didx]
 		c := d.cs[cidx]
@@ -111,8 +322,8 @@ func (d *Decoder) TryDecode() {
 		// additional source symbols have been peeled off a coded symbol after
 		// it was inserted into the decodable list and before we visit them
 		// here.
-		switch c.Count {
-		case 1:
+		switch {
+		case c.Count == 1:
 			// allocate a symbol and then XOR with the sum, so that we are
 			// guaranted to copy the sum whether or not the symbol interface is
 			// implemented as a pointer
@@ -120,21 +331,31 @@ func (d *Decoder) TryDecode() {
 			m := d.applyNewSymbol(ns, remove)
 			d.remote.addHashWithMapping(ns, m)
 			d.decoded += 1
-		case -1:
-			panic("only handle subset reconciliation")
-		case 0:
+		case c.Count == 0:
 			d.decoded += 1
+		case c.Count < 0:
+			// The degree is -1, or lower if source symbols were peeled off
+			// after it became -1 or 1, which only happens when B is not a
+			// subset of A. Keep the coded symbol in the decodable list, so
+			// that it is found again by the next call. Nothing peels it in
+			// between, because only TryDecode peels coded symbols already
+			// received.
+			d.decodable = d.decodable[didx:]
+			return ErrNotSubset
 		default:
 			// a decodable symbol does not turn undecodable, so its degree must
-			// be -1, 0, or 1
+			// be 1 or lower
 			panic("invalid degree for decodable coded symbol")
 		}
 	}
 	d.decodable = d.decodable[:0]
+	return nil
 }
 
-// Reset clears d. It is more efficient to call Reset to reuse an existing
-// Decoder than creating a new one.
+// Reset clears d, including the checksum set by SetRemoteChecksum, except for
+// the Mapping set by SetMapping or SetKey, the Scheduler, and the number of
+// confirmations set by SetConfirmation. It is more efficient to call Reset to
+// reuse an existing Decoder than creating a new one.
 func (d *Decoder) Reset() {
 	if len(d.cs) != 0 {
 		d.cs = d.cs[:0]
@@ -146,4 +367,10 @@ func (d *Decoder) Reset() {
 	d.remote.reset()
 	d.window.reset()
 	d.decoded = 0
+	if d.lost != nil {
+		d.lost = d.lost[:0]
+	}
+	d.nlost = 0
+	d.streak = 0
+	d.hasChecksum = false
 }
@@ -1,10 +1,26 @@
 package riblt
 
+import (
+	"math"
+)
+
 // symbolMapping is a mapping from a source symbol to a coded symbol. The
-// symbols are identified by their indices in codingWindow.
+// symbols are identified by their indices in codingWindow. Indices are 32-bit
+// to keep codingWindow compact; a codedIdx of windowNever means that the
+// source symbol is not mapped to any further coded symbol.
 type symbolMapping struct {
-	sourceIdx int
-	codedIdx  int
+	sourceIdx uint32
+	codedIdx  uint32
+}
+
+// windowNever is the codedIdx of a source symbol that is not mapped to any
+// further coded symbol. A codingWindow can thus generate coded symbols of
+// indices up to windowNever-1, and hold up to windowNever source symbols.
+const windowNever = math.MaxUint32
+
+// windowIndex converts an index of a Mapping to a codedIdx.
+func windowIndex(idx uint64) uint32 {
+	return uint32(min(idx, windowNever))
 }
 
 // mappingHeap implements a priority queue of symbolMappings. The priority is
@@ -49,12 +65,30 @@ func (m mappingHeap) fixTail() {
 	}
 }
 
-// codingWindow is a collection of source symbols and their mappings to coded symbols.
+// codingWindow is a collection of source symbols and their mappings to coded
+// symbols. It is laid out as a struct of arrays taking 16 bytes per source
+// symbol: the hash, the PRNG state of the mapping generator, and an entry in
+// the queue. The last index the source symbol was mapped to, which the
+// generator also needs, is the codedIdx of the queue entry. With
+// CalendarScheduler, calendar replaces queue.
 type codingWindow struct {
-	symbols  []HashType        // source symbol hashes
-	mappings []randomMapping   // mapping generators of the source symbols
-	queue    mappingHeap       // priority queue of source symbols by the next coded symbols they are mapped to
-	nextIdx  int               // index of the next coded symbol to be generated
+	symbols  []HashType     // source symbol hashes
+	prngs    []HashType     // PRNG states of the mapping generators of the source symbols
+	queue    mappingHeap    // priority queue of source symbols by the next coded symbols they are mapped to
+	calendar *calendarQueue // replaces queue if not nil
+	nextIdx  int            // index of the next coded symbol to be generated
+	mapping  Mapping        // mapping of source symbols, or nil for the built-in one
+	sum      uint64         // sum of symbolChecksum of the source symbols
+}
+
+// symbolChecksum returns the contribution of source symbol t to the checksum of
+// a set, using the finalizer of SplitMix64, a bijection, to spread t over 64
+// bits.
+func symbolChecksum(t HashType) uint64 {
+	z := uint64(t)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
 }
 
 // addSymbol inserts a symbol to the codingWindow.
@@ -64,14 +98,22 @@ func (e *codingWindow) addSymbol(t HashType) {
 
 // addHash inserts a HashType to the codingWindow.
 func (e *codingWindow) addHash(t HashType) {
-	e.addHashWithMapping(t, randomMapping{t, 0})
+	e.addHashWithMapping(t, newMapping(e.mapping, t))
 }
 
 // addHashWithMapping inserts a HashType and the current state of its mapping generator to the codingWindow.
 func (e *codingWindow) addHashWithMapping(t HashType, m randomMapping) {
+	if len(e.symbols) == windowNever {
+		panic("too many source symbols")
+	}
 	e.symbols = append(e.symbols, t)
-	e.mappings = append(e.mappings, m)
-	e.queue = append(e.queue, symbolMapping{len(e.symbols) - 1, int(m.lastIdx)})
+	e.prngs = append(e.prngs, m.prng)
+	e.sum += symbolChecksum(t)
+	if e.calendar != nil {
+		e.calendar.push(windowIndex(m.lastIdx), e.nextIdx)
+		return
+	}
+	e.queue = append(e.queue, symbolMapping{uint32(len(e.symbols) - 1), windowIndex(m.lastIdx)})
 	e.queue.fixTail()
 }
 
@@ -79,42 +121,119 @@ func (e *codingWindow) addHashWithMapping(t HashType, m randomMapping) {
 // mapped to, given as cw. The parameter direction controls how the counter
 // of cw should be modified.
 func (e *codingWindow) applyWindow(cw CodedSymbol, direction int64) CodedSymbol {
+	if e.nextIdx >= windowNever {
+		panic("too many coded symbols")
+	}
+	if e.calendar != nil {
+		return e.applyCalendar(cw, direction)
+	}
 	if len(e.queue) == 0 {
 		e.nextIdx += 1
 		return cw
 	}
-	for e.queue[0].codedIdx == e.nextIdx {
-		cw = cw.apply(e.symbols[e.queue[0].sourceIdx], direction)
+	for int(e.queue[0].codedIdx) == e.nextIdx {
+		src := e.queue[0].sourceIdx
+		cw = cw.apply(e.symbols[src], direction)
 		// generate the next mapping
-		nextMap := e.mappings[e.queue[0].sourceIdx].nextIndex()
-		e.queue[0].codedIdx = int(nextMap)
+		m := randomMapping{e.prngs[src], uint64(e.queue[0].codedIdx)}
+		e.queue[0].codedIdx = windowIndex(m.next(e.mapping))
+		e.prngs[src] = m.prng
 		e.queue.fixHead()
 	}
 	e.nextIdx += 1
 	return cw
 }
 
+// codedSymbols returns the coded symbols of indices start, start+1, ...,
+// end-1 for the source symbols in the codingWindow. It only reads e.symbols,
+// so it does not interfere with applyWindow.
+func (e *codingWindow) codedSymbols(start, end int) []CodedSymbol {
+	cs := make([]CodedSymbol, end-start)
+	for _, t := range e.symbols {
+		m := newMapping(e.mapping, t)
+		for int(m.lastIdx) < start {
+			m.next(e.mapping)
+		}
+		for int(m.lastIdx) < end {
+			cs[int(m.lastIdx)-start] = cs[int(m.lastIdx)-start].apply(t, add)
+			m.next(e.mapping)
+		}
+	}
+	return cs
+}
+
+// setMapping sets the mapping of the codingWindow, including that of the
+// source symbols already inserted. It must not be called after applyWindow.
+func (e *codingWindow) setMapping(mp Mapping) {
+	if e.nextIdx != 0 {
+		panic("setting mapping after generating coded symbols")
+	}
+	e.mapping = mp
+	e.queue = e.queue[:0]
+	if e.calendar != nil {
+		e.calendar.reset(0)
+	}
+	for i, t := range e.symbols {
+		m := newMapping(mp, t)
+		e.prngs[i] = m.prng
+		if e.calendar != nil {
+			e.calendar.push(windowIndex(m.lastIdx), 0)
+			continue
+		}
+		e.queue = append(e.queue, symbolMapping{uint32(i), windowIndex(m.lastIdx)})
+		e.queue.fixTail()
+	}
+}
+
 // reset clears a codingWindow.
 func (e *codingWindow) reset() {
 	if len(e.symbols) != 0 {
 		e.symbols = e.symbols[:0]
 	}
-	if len(e.mappings) != 0 {
-		e.mappings = e.mappings[:0]
+	if len(e.prngs) != 0 {
+		e.prngs = e.prngs[:0]
 	}
 	if len(e.queue) != 0 {
 		e.queue = e.queue[:0]
 	}
+	if e.calendar != nil {
+		e.calendar.reset(0)
+	}
 	e.nextIdx = 0
+	e.sum = 0
 }
 
 // Encoder is an incremental encoder of Rateless IBLTs. Once initialized with a
 // set of source symbols by calling AddSymbol or AddHash, a Encoder can
 // incrementally generate coded symbols in the infinite sequence defined for
 // the set. The set must not change after one or multiple coded symbols have
-// been generated by calling ProduceNextCodedSymbol.
+// been generated by calling ProduceNextCodedSymbol. An Encoder takes about 16
+// bytes of memory per source symbol. It holds fewer than 2^32 source symbols,
+// and generates fewer than 2^32 coded symbols with ProduceNextCodedSymbol.
 type Encoder codingWindow
 
+// SetMapping makes e map source symbols to coded symbols using mp. See type
+// Mapping. A nil mp selects the built-in mapping. SetMapping may be called
+// before or after source symbols are added, but it panics if called after
+// ProduceNextCodedSymbol.
+func (e *Encoder) SetMapping(mp Mapping) {
+	(*codingWindow)(e).setMapping(mp)
+}
+
+// SetKey makes e map source symbols to coded symbols using Key k. See type
+// Key. It is equivalent to SetMapping(k).
+func (e *Encoder) SetKey(k Key) {
+	e.SetMapping(k)
+}
+
+// SetScheduler makes e use Scheduler s. See type Scheduler. The coded symbols
+// do not depend on the Scheduler. SetScheduler may be called before or after
+// source symbols are added, but it panics if called after
+// ProduceNextCodedSymbol.
+func (e *Encoder) SetScheduler(s Scheduler) {
+	(*codingWindow)(e).setScheduler(s)
+}
+
 // AddSymbol adds source symbol s to e. It is undefined behavior to call AddSymbol
 // after calling ProduceNextCodedSymbol.
 func (e *Encoder) AddSymbol(s HashType) {
@@ -127,13 +246,40 @@ func (e *Encoder) AddHash(s HashType) {
 	(*codingWindow)(e).addHash(s)
 }
 
+// Checksum returns a checksum of the set of source symbols of e, for the
+// Decoder to verify the set it recovers with Decoder.SetRemoteChecksum. It is
+// the sum modulo 2^64 of a 64-bit mix of each source symbol.
+func (e *Encoder) Checksum() uint64 {
+	return e.sum
+}
+
 // ProduceNextCodedSymbol returns the next coded symbol in the sequence.
 func (e *Encoder) ProduceNextCodedSymbol() CodedSymbol {
 	return (*codingWindow)(e).applyWindow(CodedSymbol{}, add)
 }
 
-// Reset clears e. It is more efficient to call Reset to reuse an existing
-// Encoder than creating a new one.
+// ProduceCodedSymbols returns the coded symbols of indices start, start+1, ...,
+// end-1 in the sequence, without generating the ones before start. It does
+// not affect the coded symbols returned by ProduceNextCodedSymbol.
+//
+// ProduceCodedSymbols only reads the set of source symbols, so multiple
+// goroutines may call it concurrently on the same Encoder, e.g., to encode
+// disjoint ranges of the sequence in parallel, as long as no goroutine calls
+// any other method of e at the same time. The cost is proportional to the
+// size of the set times the logarithm of end, regardless of the length of the
+// range. So splitting a range across goroutines reduces latency but not the
+// total work, and ProduceNextCodedSymbol is more efficient when generating a
+// prefix of the sequence.
+func (e *Encoder) ProduceCodedSymbols(start, end int) []CodedSymbol {
+	if start < 0 || end < start {
+		panic("invalid range of coded symbols")
+	}
+	return (*codingWindow)(e).codedSymbols(start, end)
+}
+
+// Reset clears e, except for the Mapping set by SetMapping or SetKey, and the
+// Scheduler. It is more efficient to call Reset to reuse an existing Encoder
+// than creating a new one.
 func (e *Encoder) Reset() {
 	(*codingWindow)(e).reset()
 }
@@ -0,0 +1,81 @@
+package riblt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/dchest/siphash"
+)
+
+// Key is a secret that randomizes the coded symbols each source symbol is
+// mapped to. By default, the mapping of a source symbol is a fixed function of
+// the symbol, so an adversary who controls the source symbols can pick ones
+// whose mappings collide, e.g., symbols that are only mapped to the first
+// coded symbol within a long prefix, and thus prevent decoding. Key
+// implements Mapping. When the Encoder and the Decoder are set up with the
+// same Key via SetKey or SetMapping, the mapping of a source symbol is a
+// pseudorandom function of the symbol and the Key.
+// As long as the Key is fresh and hidden from the adversary, the mappings
+// are as good as random regardless of the source symbols.
+//
+// A Key should be used for a single reconciliation session. The two parties
+// may negotiate a Key by each generating a contribution with NewKey,
+// exchanging them, and calling DeriveKey. Serve and Sync do so when
+// SessionOptions.Keyed is set.
+type Key [16]byte
+
+// NewKey returns a random Key from a cryptographically secure source.
+func NewKey() (Key, error) {
+	var k Key
+	_, err := rand.Read(k[:])
+	return k, err
+}
+
+// DeriveKey returns the Key derived from the contributions of the Encoder
+// side, enc, and of the Decoder side, dec. Neither side can choose the
+// derived Key without breaking SHA-256, as long as the other side generates
+// its contribution at random.
+func DeriveKey(enc, dec Key) Key {
+	h := sha256.New()
+	h.Write([]byte("riblt key"))
+	h.Write(enc[:])
+	h.Write(dec[:])
+	var k Key
+	copy(k[:], h.Sum(nil))
+	return k
+}
+
+// seed returns the initial PRNG state of the mapping of source symbol t. It
+// is a 4-round Feistel network keyed with k, so that, like the unkeyed
+// initial state t, distinct source symbols get distinct initial states.
+func (k *Key) seed(t HashType) HashType {
+	k0 := binary.LittleEndian.Uint64(k[0:8])
+	k1 := binary.LittleEndian.Uint64(k[8:16])
+	l, r := uint16(t>>16), uint16(t)
+	var buf [4]byte
+	for round := 0; round < 4; round++ {
+		binary.LittleEndian.PutUint16(buf[0:2], uint16(round))
+		binary.LittleEndian.PutUint16(buf[2:4], r)
+		l, r = r, l^uint16(siphash.Hash(k0, k1, buf[:]))
+	}
+	return HashType(l)<<16 | HashType(r)
+}
+
+// fingerprint identifies k in snapshots. See mappingFingerprint.
+func (k *Key) fingerprint() uint64 {
+	k0 := binary.LittleEndian.Uint64(k[0:8])
+	k1 := binary.LittleEndian.Uint64(k[8:16])
+	return siphash.Hash(k0, k1, []byte("riblt snapshot"))
+}
+
+// Init implements Mapping. The initial PRNG state is derived from s and k.
+func (k Key) Init(s HashType) (HashType, uint64) {
+	return k.seed(s), 0
+}
+
+// Next implements Mapping. It follows the built-in mapping.
+func (k Key) Next(prng HashType, idx uint64) (HashType, uint64) {
+	m := randomMapping{prng, idx}
+	m.nextIndex()
+	return m.prng, m.lastIdx
+}
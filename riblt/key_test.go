@@ -0,0 +1,146 @@
+package riblt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// adversarialSymbols returns n source symbols that are all mapped to coded
+// symbol 0, and then to no other coded symbol before index 2000, when the
+// mapping is unkeyed. They exploit the linearity of the PRNG: multiplying
+// 1716067 by the PRNG multiplier leaves a tiny 64-bit product, and so do its
+// small multiples.
+func adversarialSymbols(n int) []HashType {
+	s := make([]HashType, n)
+	for i := range s {
+		s[i] = HashType(1716067 * (i + 1))
+	}
+	return s
+}
+
+func TestAdversarialSymbols(t *testing.T) {
+	for _, s := range adversarialSymbols(16) {
+		m := randomMapping{s, 0}
+		if idx := m.nextIndex(); idx < 2000 {
+			t.Errorf("symbol %d is mapped to coded symbol %d", s, idx)
+		}
+	}
+}
+
+// decodeAdversarial tries to decode a difference of adversarial source symbols
+// within maxSymbols coded symbols, keyed with key if it is not nil.
+func decodeAdversarial(key *Key, maxSymbols int) (*Decoder, bool) {
+	enc := &Encoder{}
+	dec := &Decoder{}
+	for i := 0; i < 1000; i++ {
+		s := newTestSymbol(uint64(i)).Hash()
+		enc.AddHash(s)
+		dec.AddHash(s)
+	}
+	for _, s := range adversarialSymbols(16) {
+		enc.AddHash(s)
+	}
+	if key != nil {
+		enc.SetKey(*key)
+		dec.SetKey(*key)
+	}
+	for i := 0; i < maxSymbols; i++ {
+		dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+		dec.TryDecode()
+		if dec.Decoded() {
+			return dec, true
+		}
+	}
+	return dec, false
+}
+
+func TestKeyedMapping(t *testing.T) {
+	if _, ok := decodeAdversarial(nil, 1000); ok {
+		t.Errorf("decoded adversarial symbols without a key")
+	}
+	for i := 0; i < 10; i++ {
+		k, err := NewKey()
+		if err != nil {
+			t.Fatalf("NewKey: %v", err)
+		}
+		dec, ok := decodeAdversarial(&k, 1000)
+		if !ok {
+			t.Fatalf("failed to decode adversarial symbols with key %x", k)
+		}
+		remote := make(map[HashType]struct{})
+		for _, s := range adversarialSymbols(16) {
+			remote[s] = struct{}{}
+		}
+		checkRemote(t, dec, remote)
+	}
+}
+
+func TestKeySeedIsPermutation(t *testing.T) {
+	k := DeriveKey(Key{1}, Key{2})
+	symbols := make(map[HashType]struct{})
+	seeds := make(map[HashType]struct{})
+	for t0 := HashType(0); t0 < 1<<16; t0++ {
+		for _, s := range []HashType{t0, t0 << 16, ^t0} {
+			symbols[s] = struct{}{}
+			seeds[k.seed(s)] = struct{}{}
+		}
+	}
+	if len(seeds) != len(symbols) {
+		t.Errorf("%d distinct seeds for %d distinct symbols", len(seeds), len(symbols))
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	a, b := Key{1}, Key{2}
+	if DeriveKey(a, b) != DeriveKey(a, b) {
+		t.Errorf("DeriveKey is not deterministic")
+	}
+	if DeriveKey(a, b) == DeriveKey(b, a) {
+		t.Errorf("DeriveKey ignores the order of contributions")
+	}
+}
+
+func TestSetKeyAfterEncoding(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("SetKey did not panic after encoding")
+		}
+	}()
+	enc := Encoder{}
+	enc.AddHash(1)
+	enc.ProduceNextCodedSymbol()
+	enc.SetKey(Key{})
+}
+
+func TestKeyedSession(t *testing.T) {
+	enc, dec, remote := newTestSets(1000, 1000)
+	opts := &SessionOptions{Keyed: true}
+	serveErr, syncErr := runSession(t, enc, dec, opts, opts)
+	if serveErr != nil {
+		t.Errorf("Serve: %v", serveErr)
+	}
+	if syncErr != nil {
+		t.Errorf("Sync: %v", syncErr)
+	}
+	checkRemote(t, dec, remote)
+	if enc.mapping == nil || enc.mapping != dec.window.mapping {
+		t.Errorf("Encoder and Decoder did not agree on a key")
+	}
+}
+
+func TestKeyedSessionMismatch(t *testing.T) {
+	enc, dec, _ := newTestSets(10, 10)
+	alice, bob := net.Pipe()
+	defer alice.Close()
+	defer bob.Close()
+	done := make(chan error)
+	go func() {
+		done <- Serve(context.Background(), alice, enc, &SessionOptions{Keyed: true})
+	}()
+	go Sync(context.Background(), bob, dec, nil)
+	if err := <-done; !errors.Is(err, ErrProtocol) {
+		t.Errorf("Serve returned %v, expected ErrProtocol", err)
+	}
+}
@@ -2,6 +2,7 @@ package riblt
 
 import (
 	"math"
+	"math/bits"
 )
 
 // randomMapping generates a sequence of indices indicating the coded symbols
@@ -14,20 +15,242 @@ type randomMapping struct {
 	lastIdx uint64 // the last index the symbol was mapped to
 }
 
+// prngMultiplier is the multiplier of the PRNG update rule. It must be coprime
+// to 2^64.
+const prngMultiplier = 0xda942042e4dd58b5
+
 // nextIndex returns the next index in the sequence.
 func (s *randomMapping) nextIndex() uint64 {
-	// Update the PRNG. TODO: prove that the following update rule gives us
-	// high quality randomness, assuming the multiplier is coprime to 2^64.
-	r := uint64(s.prng) * 0xda942042e4dd58b5
+	// Update the PRNG. We have not proved that the following update rule
+	// gives us high quality randomness. Instead, the tests in mapping_test.go
+	// check the distribution of the indices, and run chi-square and serial
+	// correlation tests on r, over millions of seeds. Only the high bits of r
+	// matter, as the low bits of the 32-bit state are weak (bit 0 never
+	// changes, for example).
+	r := uint64(s.prng) * prngMultiplier
 	s.prng = uint32(r)
-	// Calculate the difference from the current index (s.lastIdx) to the next
-	// index. See the paper for details. We use the approximated form
-	//   diff = (1.5+i)((1-u)^(-1/2)-1)
-	// where i is the current index, i.e., lastIdx; u is a number uniformly
-	// sampled from [0, 1). We apply the following optimization. Notice that
-	// our u actually comes from sampling a random uint64 r, and then dividing
-	// it by maxUint64, i.e., 1<<64. So we can replace (1-u)^(-1/2) with
-	//   1<<32 / sqrt(r).
-	s.lastIdx += uint64(math.Ceil((float64(s.lastIdx) + 1.5) * ((1<<32)/math.Sqrt(float64(r)+1) - 1)))
+	s.lastIdx = addIndex(s.lastIdx, indexGap(s.lastIdx, r))
 	return s.lastIdx
 }
+
+// indexGap returns the difference from index i to the next index, given a
+// uniformly random r. See the paper for details. We use the approximated form
+//   diff = ceil((1.5+i)((1-u)^(-1/2)-1))
+// where u is a number uniformly sampled from [0, 1). Notice that our u
+// actually comes from sampling a random uint64 r, and then dividing it by
+// maxUint64, i.e., 1<<64. So we can replace (1-u)^(-1/2) with
+//   1<<32 / sqrt(r).
+// To get the same result on every platform, and in ports to other languages,
+// we only use integer arithmetic. Let s = max(1, isqrt(r)). Then
+//   diff = ceil((2i+3)(1<<32 - s) / 2s).
+// The distribution of diff is provably that of the form above, up to an error
+// of 2^-31. For any g, diff <= g exactly when s >= k, where k is the smallest
+// integer not less than (1<<32)(1.5+i)/(1.5+i+g). There are k*k values of r
+// with isqrt(r) < k, so P(diff <= g) = 1 - k*k/2^64, which is within 2^-31 of
+// 1 - ((1.5+i)/(1.5+i+g))^2, the distribution for a real-valued u.
+func indexGap(i uint64, r uint64) uint64 {
+	if i >= neverIndex {
+		return 0
+	}
+	s := max(1, isqrt(r))
+	hi, lo := bits.Mul64(2*i+3, 1<<32-s)
+	if hi >= 2*s {
+		// the quotient does not fit in 64 bits
+		return neverIndex
+	}
+	q, rem := bits.Div64(hi, lo, 2*s)
+	if rem != 0 {
+		q += 1
+	}
+	return q
+}
+
+// addIndex returns i+diff, saturated at neverIndex.
+func addIndex(i, diff uint64) uint64 {
+	if diff >= neverIndex-min(i, neverIndex) {
+		return neverIndex
+	}
+	return i + diff
+}
+
+// isqrt returns the largest integer whose square is not larger than r.
+func isqrt(r uint64) uint64 {
+	if r == 0 {
+		return 0
+	}
+	// Shift r by an even number of bits to n in [2^62, 2^64), and look up an
+	// upper bound of sqrt(n) by its top byte. It is accurate to 7 bits, so
+	// two steps of Newton's method get us within a few units of the root,
+	// still from above.
+	sh := uint(bits.LeadingZeros64(r)) &^ 1
+	x := sqrtTable[(r<<sh)>>56] >> (sh / 2)
+	x = (x + r/x) / 2
+	x = (x + r/x) / 2
+	for x > math.MaxUint32 || x*x > r {
+		x -= 1
+	}
+	return x
+}
+
+// sqrtTable holds, for each top byte b of a 64-bit integer n in [2^62, 2^64),
+// an upper bound of sqrt(n).
+var sqrtTable = func() (t [256]uint64) {
+	for b := uint64(64); b < 256; b++ {
+		// Newton's method decreases monotonically to the root when
+		// starting from above it.
+		r := (b+1)<<56 - 1
+		x := uint64(1) << 32
+		for {
+			y := (x + r/x) / 2
+			if y >= x {
+				break
+			}
+			x = y
+		}
+		t[b] = x + 1
+	}
+	return t
+}()
+
+// neverIndex is the index returned by a Mapping when a source symbol is not
+// mapped to any further coded symbol. Indices are compared as ints, so this is
+// the largest index we can represent.
+const neverIndex uint64 = math.MaxInt64
+
+// Mapping specifies the coded symbols that each source symbol is mapped to, as
+// a sequence of strictly increasing indices generated from a 32-bit PRNG
+// state. Encoders, Decoders and Sketches use a built-in mapping where index i
+// is present with probability about 1/(1+i/2), unless a different Mapping is
+// set with SetMapping (or passed to the Sketch methods that take one). Both
+// sides of a reconciliation must use the same Mapping. Implementations must be
+// deterministic and safe for concurrent use.
+//
+// The number of coded symbols required for decoding depends on the Mapping.
+// For example, a RegularMapping only ever maps source symbols to a fixed
+// number of coded symbols, so it is not rateless. See BenchmarkMappings.
+type Mapping interface {
+	// Init returns the initial PRNG state of the mapping of source symbol s,
+	// and the index of the first coded symbol s is mapped to.
+	Init(s HashType) (prng HashType, idx uint64)
+	// Next returns the PRNG state after, and the index of, the coded symbol
+	// following idx in the mapping whose PRNG state is prng. The returned
+	// index must be larger than idx. An index of math.MaxInt64 or larger
+	// indicates that there are no more coded symbols in the mapping.
+	Next(prng HashType, idx uint64) (HashType, uint64)
+}
+
+// newMapping returns the mapping generator of source symbol t under mp, which
+// may be nil for the built-in mapping.
+func newMapping(mp Mapping, t HashType) randomMapping {
+	if mp == nil {
+		return randomMapping{t, 0}
+	}
+	prng, idx := mp.Init(t)
+	return randomMapping{prng, min(idx, neverIndex)}
+}
+
+// next is like nextIndex, but generates the next index under mp, which may be
+// nil for the built-in mapping.
+func (s *randomMapping) next(mp Mapping) uint64 {
+	if mp == nil {
+		return s.nextIndex()
+	}
+	prng, idx := mp.Next(s.prng, s.lastIdx)
+	s.prng, s.lastIdx = prng, min(idx, neverIndex)
+	return s.lastIdx
+}
+
+// FloatMapping is the built-in mapping of earlier versions of this package,
+// which computed the gaps between indices in floating point. The results may
+// differ across platforms, so use it only to reconcile with peers that still
+// do so. It maps source symbols differently from the built-in mapping, but the
+// distribution of indices is the same.
+type FloatMapping struct{}
+
+// Init implements Mapping. The first index is always 0.
+func (FloatMapping) Init(s HashType) (HashType, uint64) {
+	return s, 0
+}
+
+// Next implements Mapping.
+func (FloatMapping) Next(prng HashType, idx uint64) (HashType, uint64) {
+	r := uint64(prng) * prngMultiplier
+	diff := math.Ceil((float64(idx) + 1.5) * ((1<<32)/math.Sqrt(float64(r)+1) - 1))
+	if float64(idx)+diff >= float64(neverIndex) {
+		return uint32(r), neverIndex
+	}
+	return uint32(r), idx + uint64(diff)
+}
+
+// AlphaMapping is a Mapping where index i is present with probability about
+// 1/(1+Alpha*i). The built-in mapping is close to AlphaMapping{0.5}; a larger
+// Alpha makes coded symbols sparser. Alpha must be in (0, 1].
+type AlphaMapping struct {
+	Alpha float64
+}
+
+// Init implements Mapping. The first index is always 0.
+func (a AlphaMapping) Init(s HashType) (HashType, uint64) {
+	return s, 0
+}
+
+// Next implements Mapping. It generalizes randomMapping.nextIndex to
+//   diff = (1/alpha-0.5+i)((1-u)^(-alpha)-1),
+// which is the built-in rule when alpha is 1/2.
+func (a AlphaMapping) Next(prng HashType, idx uint64) (HashType, uint64) {
+	r := uint64(prng) * prngMultiplier
+	// (1-u)^(-alpha) = (1<<64 / (r+1))^alpha
+	scale := math.Exp(a.Alpha * (64*math.Ln2 - math.Log(float64(r)+1)))
+	diff := math.Ceil((float64(idx) + 1/a.Alpha - 0.5) * (scale - 1))
+	if diff < 1 {
+		diff = 1
+	}
+	if float64(idx)+diff >= float64(neverIndex) {
+		return uint32(r), neverIndex
+	}
+	return uint32(r), idx + uint64(diff)
+}
+
+// RegularMapping is a Mapping of a traditional, fixed-size IBLT: each source
+// symbol is mapped to exactly Degree of the first Cells coded symbols, one in
+// each of Degree equally sized partitions, and to no coded symbol after them.
+// Decoding fails unless the Decoder receives all coded symbols in the
+// partitions, and Cells is large enough for the difference. Decoder.Decoded
+// is not meaningful before all of them are received.
+type RegularMapping struct {
+	Degree int
+	Cells  int
+}
+
+// width returns the size of each partition.
+func (g RegularMapping) width() uint64 {
+	if g.Degree <= 0 || g.Cells < g.Degree {
+		panic("invalid RegularMapping")
+	}
+	return uint64(g.Cells / g.Degree)
+}
+
+// cell returns the index of the coded symbol of source symbol s in partition
+// p, using the finalizer of SplitMix64 as the hash function.
+func (g RegularMapping) cell(s HashType, p uint64) uint64 {
+	z := uint64(s) | p<<32
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	w := g.width()
+	return p*w + z%w
+}
+
+// Init implements Mapping.
+func (g RegularMapping) Init(s HashType) (HashType, uint64) {
+	return s, g.cell(s, 0)
+}
+
+// Next implements Mapping. The PRNG state is the source symbol itself.
+func (g RegularMapping) Next(s HashType, idx uint64) (HashType, uint64) {
+	p := idx/g.width() + 1
+	if p >= uint64(g.Degree) {
+		return s, neverIndex
+	}
+	return s, g.cell(s, p)
+}
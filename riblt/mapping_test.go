@@ -1,6 +1,12 @@
 package riblt
 
 import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"math/rand"
 	"testing"
 )
 
@@ -8,6 +14,457 @@ func BenchmarkMapping(b *testing.B) {
 	m := randomMapping{123456789, 0}
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		m.nextIndex()
+		// Restart before the indices saturate at neverIndex.
+		if m.nextIndex() > 1<<40 {
+			m.lastIdx = 0
+		}
+	}
+}
+
+func BenchmarkFloatMapping(b *testing.B) {
+	m := randomMapping{123456789, 0}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if m.next(FloatMapping{}) > 1<<40 {
+			m.lastIdx = 0
+		}
+	}
+}
+
+// testMappings are the mappings exercised by the tests and benchmarks below.
+// nil is the built-in mapping.
+var testMappings = []struct {
+	name    string
+	mapping Mapping
+}{
+	{"builtin", nil},
+	{"float", FloatMapping{}},
+	{"key", Key{1, 2, 3}},
+	{"alpha=0.25", AlphaMapping{0.25}},
+	{"alpha=0.5", AlphaMapping{0.5}},
+	{"alpha=0.75", AlphaMapping{0.75}},
+	{"alpha=1", AlphaMapping{1}},
+	{"regular,k=3", RegularMapping{3, 3000}},
+	{"regular,k=4", RegularMapping{4, 3000}},
+}
+
+func TestMappingsIncreasing(t *testing.T) {
+	for _, tc := range testMappings {
+		for s := HashType(0); s < 1000; s++ {
+			m := newMapping(tc.mapping, s*2654435761)
+			for i := 0; i < 100 && m.lastIdx != neverIndex; i++ {
+				last := m.lastIdx
+				if m.next(tc.mapping) <= last {
+					t.Fatalf("%s: index %d follows %d", tc.name, m.lastIdx, last)
+				}
+			}
+		}
+	}
+}
+
+func TestRegularMapping(t *testing.T) {
+	g := RegularMapping{Degree: 4, Cells: 1003}
+	for s := HashType(0); s < 1000; s++ {
+		var indices []uint64
+		m := newMapping(g, s)
+		for m.lastIdx != neverIndex {
+			indices = append(indices, m.lastIdx)
+			m.next(g)
+		}
+		if len(indices) != g.Degree {
+			t.Fatalf("symbol %d mapped to %d coded symbols", s, len(indices))
+		}
+		for p, idx := range indices {
+			if idx/250 != uint64(p) {
+				t.Errorf("symbol %d mapped to coded symbol %d in partition %d", s, idx, p)
+			}
+		}
+	}
+}
+
+func TestAlphaMappingMatchesBuiltin(t *testing.T) {
+	// AlphaMapping{0.5} computes the same formula as the built-in mapping
+	// in a different way, so rounding rarely makes them disagree.
+	total, same := 0, 0
+	for s := HashType(0); s < 10000; s++ {
+		a := newMapping(AlphaMapping{0.5}, s)
+		b := newMapping(nil, s)
+		for i := 0; i < 20; i++ {
+			total += 1
+			if a.next(AlphaMapping{0.5}) == b.next(nil) {
+				same += 1
+			} else {
+				break
+			}
+		}
+	}
+	if float64(same) < 0.999*float64(total) {
+		t.Errorf("only %d of %d indices agree", same, total)
+	}
+}
+
+func TestMappingsDecode(t *testing.T) {
+	for _, tc := range testMappings {
+		t.Run(tc.name, func(t *testing.T) {
+			enc, dec, remote := newTestSets(1000, 1000)
+			enc.SetMapping(tc.mapping)
+			dec.SetMapping(tc.mapping)
+			// A RegularMapping may look decoded before all cells arrive.
+			cells := 1
+			if g, ok := tc.mapping.(RegularMapping); ok {
+				cells = g.Cells
+			}
+			for i := 0; i < 100000 && (i < cells || !dec.Decoded()); i++ {
+				dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+				dec.TryDecode()
+			}
+			checkRemote(t, dec, remote)
+
+			// Sketches agree with the Encoder.
+			s := make(Sketch, 3000)
+			for v := range remote {
+				s.AddSymbolWithMapping(v, tc.mapping)
+			}
+			fwd, _, succ := s.DecodeWithMapping(tc.mapping)
+			if !succ || len(fwd) != len(remote) {
+				t.Errorf("sketch decoded %d symbols, success %t", len(fwd), succ)
+			}
+			fwd, _, succ = s.DecodeParallelWithMapping(4, tc.mapping)
+			if !succ || len(fwd) != len(remote) {
+				t.Errorf("parallel decoded %d symbols, success %t", len(fwd), succ)
+			}
+			for _, v := range fwd {
+				s.RemoveSymbolWithMapping(v, tc.mapping)
+			}
+			for i, c := range s {
+				if c != (CodedSymbol{}) {
+					t.Fatalf("coded symbol %d is %v after removing all symbols", i, c)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMappings(bc *testing.B) {
+	const d = 1000
+	for _, tc := range testMappings {
+		bc.Run(tc.name, func(b *testing.B) {
+			cells := 1
+			if g, ok := tc.mapping.(RegularMapping); ok {
+				cells = g.Cells
+			}
+			ncw := 0
+			nfail := 0
+			var nextId uint64
+			for iter := 0; iter < b.N; iter++ {
+				enc := Encoder{}
+				dec := Decoder{}
+				enc.SetMapping(tc.mapping)
+				dec.SetMapping(tc.mapping)
+				for i := 0; i < d; i++ {
+					enc.AddSymbol(newTestSymbol(nextId).Hash())
+					nextId += 1
+				}
+				for i := 0; i < d; i++ {
+					s := newTestSymbol(nextId).Hash()
+					nextId += 1
+					enc.AddSymbol(s)
+					dec.AddSymbol(s)
+				}
+				n := 0
+				for {
+					dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+					dec.TryDecode()
+					n += 1
+					if n >= cells && dec.Decoded() {
+						break
+					}
+					if n == 100*d || n == cells && cells > 1 {
+						nfail += 1
+						break
+					}
+				}
+				ncw += n
+			}
+			b.ReportMetric(float64(ncw)/float64(b.N*d), "symbols/diff")
+			b.ReportMetric(float64(nfail)/float64(b.N), "fail")
+		})
+	}
+}
+
+// statSeeds returns the number of seeds for the statistical tests of the
+// built-in mapping, and the i-th seed. The seeds are spread over the 32-bit
+// state space with a Weyl sequence.
+func statSeeds() (int, func(i int) HashType) {
+	n := 1 << 22
+	if testing.Short() {
+		n = 1 << 16
+	}
+	return n, func(i int) HashType {
+		return HashType(uint32(i) * 0x9e3779b9)
+	}
+}
+
+// expectedHits returns the probability that each index below n is present in
+// the sequence of the built-in mapping, assuming a uniformly random u, under
+// the gap rule of indexGap,
+//   diff = ceil((i+1.5)((1-u)^(-1/2)-1)),
+// for which P(diff <= g) = 1-((i+1.5)/(i+1.5+g))^2. The probabilities are
+// within 4% of 1/(1+i/2), and converge to it quickly: the ceiling only makes
+// a difference for small i.
+func expectedHits(n int) []float64 {
+	q := make([]float64, n)
+	q[0] = 1
+	for j := 0; j < n; j++ {
+		a := float64(j) + 1.5
+		prev := 0.0
+		for g := 1; j+g < n; g++ {
+			f := 1 - (a/(a+float64(g)))*(a/(a+float64(g)))
+			q[j+g] += q[j] * (f - prev)
+			prev = f
+		}
+	}
+	return q
+}
+
+func TestMappingHitProbability(t *testing.T) {
+	for _, mp := range []Mapping{nil, FloatMapping{}} {
+		t.Run(fmt.Sprintf("%T", mp), func(t *testing.T) {
+			testMappingHitProbability(t, mp)
+		})
+	}
+}
+
+func testMappingHitProbability(t *testing.T, mp Mapping) {
+	const exact = 1 << 10 // indices checked one by one
+	const maxIdx = 1 << 16
+	n, seed := statSeeds()
+	hits := make([]int, maxIdx)
+	for k := 0; k < n; k++ {
+		m := newMapping(mp, seed(k))
+		for m.lastIdx < maxIdx {
+			hits[m.lastIdx] += 1
+			m.next(mp)
+		}
+	}
+
+	q := expectedHits(exact)
+	for i := 0; i < exact; i++ {
+		ideal := 1 / (1 + float64(i)/2)
+		if math.Abs(q[i]/ideal-1) > 0.04+1e-9 || i >= 32 && math.Abs(q[i]/ideal-1) > 0.0005 {
+			t.Errorf("index %d: expected probability %.5f, far from 1/(1+i/2) = %.5f", i, q[i], ideal)
+		}
+		mean := float64(n) * q[i]
+		sd := math.Sqrt(mean * (1 - q[i]))
+		if math.Abs(float64(hits[i])-mean) > 6*sd+1 {
+			t.Errorf("index %d: hit by %d of %d seeds, expected %.0f +- %.0f", i, hits[i], n, mean, sd)
+		}
+	}
+
+	// Beyond that, compare the number of hits in each octave against
+	// 1/(1+i/2). The hits of a seed within an octave are about Poisson.
+	for lo := exact; lo < maxIdx; lo *= 2 {
+		ideal, got := 0.0, 0
+		for i := lo; i < 2*lo; i++ {
+			ideal += 1 / (1 + float64(i)/2)
+			got += hits[i]
+		}
+		ratio := float64(got) / (float64(n) * ideal)
+		tol := 0.001 + 6/math.Sqrt(float64(n)*ideal)
+		t.Logf("indices [%d, %d): %.4f of 1/(1+i/2)", lo, 2*lo, ratio)
+		if math.Abs(ratio-1) > tol {
+			t.Errorf("indices [%d, %d): hit %.4f times as often as 1/(1+i/2)", lo, 2*lo, ratio)
+		}
+	}
+}
+
+// prngOutputs calls f with the PRNG outputs r that the first steps of the
+// mapping of each seed are computed from, as uniform numbers in [0, 1).
+func prngOutputs(steps int, f func(k, step int, r uint64)) {
+	n, seed := statSeeds()
+	for k := 0; k < n; k++ {
+		m := randomMapping{seed(k), 0}
+		for step := 0; step < steps; step++ {
+			f(k, step, uint64(m.prng)*prngMultiplier)
+			m.nextIndex()
+		}
+	}
+}
+
+func TestMappingPRNGChiSquare(t *testing.T) {
+	// Chi-square tests with 255 degrees of freedom on a byte of r, for
+	// each step separately. The threshold has a p-value of about 1e-5.
+	const steps = 8
+	const threshold = 370
+	for _, shift := range []uint{56, 48, 32} {
+		counts := make([][256]int, steps)
+		prngOutputs(steps, func(k, step int, r uint64) {
+			counts[step][byte(r>>shift)] += 1
+		})
+		for step := range counts {
+			n := 0
+			for _, c := range counts[step] {
+				n += c
+			}
+			mean := float64(n) / 256
+			chi2 := 0.0
+			for _, c := range counts[step] {
+				chi2 += (float64(c) - mean) * (float64(c) - mean) / mean
+			}
+			if chi2 > threshold {
+				t.Errorf("bits [%d, %d) at step %d: chi-square %.1f exceeds %d", shift, shift+8, step, chi2, threshold)
+			}
+		}
+	}
+}
+
+func TestMappingPRNGSerialCorrelation(t *testing.T) {
+	// Pearson correlation between u at step j and u at step j+lag of the
+	// same mapping, pooled over seeds.
+	const steps = 8
+	n, _ := statSeeds()
+	u := make([]float64, steps)
+	for lag := 1; lag <= 3; lag++ {
+		var sx, sy, sxx, syy, sxy float64
+		pairs := 0
+		prngOutputs(steps, func(k, step int, r uint64) {
+			u[step] = float64(r) / (1 << 64)
+			if step < steps-1 {
+				return
+			}
+			for j := 0; j+lag < steps; j++ {
+				x, y := u[j], u[j+lag]
+				sx += x
+				sy += y
+				sxx += x * x
+				syy += y * y
+				sxy += x * y
+				pairs += 1
+			}
+		})
+		p := float64(pairs)
+		cov := sxy/p - sx/p*sy/p
+		rho := cov / math.Sqrt((sxx/p-sx/p*sx/p)*(syy/p-sy/p*sy/p))
+		// Pairs from the same seed overlap, so allow some slack beyond
+		// the standard error of independent pairs.
+		if bound := 6 / math.Sqrt(float64(n)); math.Abs(rho) > bound {
+			t.Errorf("lag %d: correlation %.5f exceeds %.5f", lag, rho, bound)
+		}
+	}
+}
+
+// mappingDigest returns a digest of the first indices of mapping mp of a range
+// of source symbols.
+func mappingDigest(mp Mapping) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	for s := HashType(0); s < 10000; s++ {
+		m := newMapping(mp, s*0x9e3779b9)
+		for i := 0; i < 16; i++ {
+			binary.LittleEndian.PutUint64(buf[:], m.next(mp))
+			h.Write(buf[:])
+		}
+	}
+	return h.Sum64()
+}
+
+func TestMappingDigest(t *testing.T) {
+	// The coded symbols of the built-in mapping must not change, or
+	// existing peers and ports can no longer reconcile with us. Update the
+	// digest only for a deliberate, versioned change of the mapping.
+	cases := []struct {
+		mapping Mapping
+		want    uint64
+	}{
+		{nil, 0x1466163ebc47af9},
+		// Earlier versions wrapped around instead of saturating at
+		// neverIndex, after source symbol 0 whose PRNG is stuck at 0,
+		// which changed the digest from 0xea26e54a0da043d2.
+		{FloatMapping{}, 0xe7437335ee11c7},
+	}
+	for _, tc := range cases {
+		if got := mappingDigest(tc.mapping); got != tc.want {
+			t.Errorf("digest of %T is %#x, expected %#x", tc.mapping, got, tc.want)
+		}
+	}
+}
+
+func TestIsqrt(t *testing.T) {
+	check := func(r uint64) {
+		want := new(big.Int).Sqrt(new(big.Int).SetUint64(r)).Uint64()
+		if got := isqrt(r); got != want {
+			t.Fatalf("isqrt(%d) = %d, expected %d", r, got, want)
+		}
+	}
+	for r := uint64(0); r < 1<<16; r++ {
+		check(r)
+	}
+	for k := uint64(1); k < 1<<32; k += 99991 {
+		check(k*k - 1)
+		check(k * k)
+		check(k*k + 1)
+	}
+	check(1<<64 - 1)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		check(rng.Uint64() >> rng.Intn(64))
+	}
+}
+
+func TestIndexGapDistribution(t *testing.T) {
+	// For index i and gap g, indexGap(i, r) <= g exactly when r >= k*k,
+	// where k is the smallest integer not less than (1<<32)(1.5+i)/(1.5+i+g).
+	// P(r >= k*k) must be close to P(diff <= g) for a real-valued u.
+	for _, i := range []uint64{0, 1, 2, 10, 100, 12345, 1 << 30, 1 << 40} {
+		for _, g := range []uint64{1, 2, 3, 10, 1000, 1 << 20, 1 << 40} {
+			num := new(big.Int).Lsh(big.NewInt(int64(2*i+3)), 32)
+			den := new(big.Int).SetUint64(2*i + 3 + 2*g)
+			k, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+			if rem.Sign() != 0 {
+				k.Add(k, big.NewInt(1))
+			}
+			if k.Cmp(big.NewInt(1)) <= 0 || k.BitLen() > 32 {
+				continue
+			}
+			kk := k.Uint64() * k.Uint64()
+			if got := indexGap(i, kk); got > g {
+				t.Errorf("indexGap(%d, %d) = %d, expected at most %d", i, kk, got, g)
+			}
+			if got := indexGap(i, kk-1); got <= g {
+				t.Errorf("indexGap(%d, %d) = %d, expected more than %d", i, kk-1, got, g)
+			}
+			a := float64(i) + 1.5
+			ideal := 1 - (a/(a+float64(g)))*(a/(a+float64(g)))
+			p := 1 - float64(kk)/(1<<64)
+			if math.Abs(p-ideal) > 1.0/(1<<31) {
+				t.Errorf("P(gap <= %d at index %d) = %g, expected %g", g, i, p, ideal)
+			}
+		}
+	}
+	if got := indexGap(neverIndex-10, 1); got != neverIndex {
+		t.Errorf("indexGap overflowed to %d", got)
+	}
+}
+
+func TestFloatMapping(t *testing.T) {
+	// The float and integer rules compute the same formula, so they differ
+	// only when rounding lands on the other side of an integer. The error of
+	// 2^32/sqrt(r) grows with the index, and so does the rate of
+	// disagreement, to about 1 in 4000 at index 2^20.
+	total, same := 0, 0
+	for s := HashType(0); s < 100000; s++ {
+		a := newMapping(nil, s*0x9e3779b9)
+		b := newMapping(FloatMapping{}, s*0x9e3779b9)
+		for a.lastIdx < 1<<20 {
+			total += 1
+			if a.next(nil) != b.next(FloatMapping{}) {
+				break
+			}
+			same += 1
+		}
+	}
+	t.Logf("%d of %d indices agree", same, total)
+	if float64(same) < 0.999*float64(total) {
+		t.Errorf("only %d of %d indices agree", same, total)
 	}
 }
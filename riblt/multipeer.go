@@ -0,0 +1,76 @@
+package riblt
+
+// MultiDecoder is a Decoder that receives coded symbols from multiple peers,
+// identified by integers, that hold the same remote set A. Coded symbols of
+// the same index are identical across peers, so the MultiDecoder merges the
+// streams by index, and the peers may split the work of sending a prefix of
+// the sequence, e.g., by each sending a disjoint range of indices or every
+// k-th index. A peer whose set is A plus some source symbols known to the
+// MultiDecoder may participate too, after the extra source symbols are
+// declared with SubtractPeerHash.
+//
+// The zero value of MultiDecoder is ready to use. Methods of the embedded
+// Decoder work as usual; for example, AddCodedSymbolAt passes a coded symbol
+// that has no extra source symbols to subtract.
+type MultiDecoder struct {
+	Decoder
+	// extra source symbols of each peer, beyond A
+	extra map[int]*Encoder
+}
+
+// SubtractPeerHash declares that the set of peer contains source symbol s in
+// addition to A, so that s is subtracted from the coded symbols of peer
+// before they are merged. It is undefined behavior to call SubtractPeerHash
+// for a peer after AddPeerCodedSymbolAt has been called for the same peer.
+func (d *MultiDecoder) SubtractPeerHash(peer int, s HashType) {
+	if d.extra == nil {
+		d.extra = make(map[int]*Encoder)
+	}
+	e, ok := d.extra[peer]
+	if !ok {
+		e = &Encoder{}
+		e.SetMapping(d.window.mapping)
+		d.extra[peer] = e
+	}
+	e.AddHash(s)
+}
+
+// SetMapping is like Decoder.SetMapping, and also applies to the extra source
+// symbols of the peers.
+func (d *MultiDecoder) SetMapping(mp Mapping) {
+	d.Decoder.SetMapping(mp)
+	for _, e := range d.extra {
+		e.SetMapping(mp)
+	}
+}
+
+// SetKey is equivalent to SetMapping(k).
+func (d *MultiDecoder) SetKey(k Key) {
+	d.SetMapping(k)
+}
+
+// AddPeerCodedSymbolAt passes the coded symbol of index i in the sequence of
+// peer to d. Coded symbols may be passed in any order, as in
+// Decoder.AddCodedSymbolAt. If the same index is passed by multiple peers,
+// only the first coded symbol is used. Subtracting the extra source symbols
+// of peer costs time proportional to their number times the logarithm of i.
+// Negative indices are ignored.
+func (d *MultiDecoder) AddPeerCodedSymbolAt(peer int, i int, c CodedSymbol) {
+	if i < 0 {
+		return
+	}
+	if e, ok := d.extra[peer]; ok {
+		x := e.ProduceCodedSymbols(i, i+1)[0]
+		c.Hash ^= x.Hash
+		c.Count -= x.Count
+	}
+	d.AddCodedSymbolAt(i, c)
+}
+
+// Reset clears d, including the extra source symbols of the peers.
+func (d *MultiDecoder) Reset() {
+	d.Decoder.Reset()
+	for peer := range d.extra {
+		delete(d.extra, peer)
+	}
+}
@@ -0,0 +1,91 @@
+package riblt
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// newTestPeers is like newTestSets, but returns npeers Encoders holding the
+// same set, and a MultiDecoder.
+func newTestPeers(npeers, nremote, ncommon int) ([]*Encoder, *MultiDecoder, map[HashType]struct{}) {
+	enc, dec, remote := newTestSets(nremote, ncommon)
+	peers := []*Encoder{enc}
+	for len(peers) < npeers {
+		enc, _, _ := newTestSets(nremote, ncommon)
+		peers = append(peers, enc)
+	}
+	return peers, &MultiDecoder{Decoder: *dec}, remote
+}
+
+// decodeFromPeers runs a MultiDecoder against the given peers, each sending
+// every len(peers)-th coded symbol of its sequence, with some of them lost.
+// It returns the number of coded symbols sent in total.
+func decodeFromPeers(t *testing.T, dec *MultiDecoder, peers []*Encoder, loss float64) int {
+	t.Helper()
+	rng := rand.New(rand.NewSource(7))
+	sent := 0
+	for i := 0; !dec.Decoded() || i == 0; i++ {
+		p := i % len(peers)
+		c := peers[p].ProduceCodedSymbols(i, i+1)[0]
+		sent += 1
+		if rng.Float64() >= loss {
+			dec.AddPeerCodedSymbolAt(p, i, c)
+		}
+		dec.TryDecode()
+		if sent > 1000000 {
+			t.Fatalf("failed to decode after %d coded symbols", sent)
+		}
+	}
+	return sent
+}
+
+func TestMultiDecoderIdenticalPeers(t *testing.T) {
+	const nremote = 1000
+	const ncommon = 1000
+	peers, dec, remote := newTestPeers(3, nremote, ncommon)
+
+	sent := decodeFromPeers(t, dec, peers, 0)
+	checkRemote(t, &dec.Decoder, remote)
+
+	// Every index was sent by exactly one peer, so each peer sends about a
+	// third of what a single peer has to send for the same sets.
+	single, sdec, _ := newTestPeers(1, nremote, ncommon)
+	baseline := decodeFromPeers(t, sdec, single, 0)
+	perPeer := (sent + len(peers) - 1) / len(peers)
+	if perPeer >= baseline/2 {
+		t.Errorf("each of %d peers sent %d coded symbols, a single peer %d", len(peers), perPeer, baseline)
+	}
+}
+
+func TestMultiDecoderSubtractPeerHash(t *testing.T) {
+	const nremote = 500
+	const ncommon = 500
+	peers, dec, remote := newTestPeers(3, nremote, ncommon)
+	// Peers 1 and 2 hold extra source symbols that the MultiDecoder knows
+	// about, but does not hold itself.
+	for i := 0; i < 20; i++ {
+		s := newTestSymbol(uint64(1000000 + i)).Hash()
+		peers[1].AddHash(s)
+		dec.SubtractPeerHash(1, s)
+	}
+	s := newTestSymbol(2000000).Hash()
+	peers[2].AddHash(s)
+	dec.SubtractPeerHash(2, s)
+
+	decodeFromPeers(t, dec, peers, 0.2)
+	checkRemote(t, &dec.Decoder, remote)
+
+	dec.Reset()
+	if len(dec.extra) != 0 {
+		t.Errorf("Reset left %d peers with extra source symbols", len(dec.extra))
+	}
+}
+
+func TestMultiDecoderNegativeIndex(t *testing.T) {
+	_, dec, _ := newTestPeers(1, 0, 10)
+	dec.SubtractPeerHash(1, newTestSymbol(1000000).Hash())
+	dec.AddPeerCodedSymbolAt(1, -1, CodedSymbol{1, 1})
+	if s := dec.Stats(); s.Received != 0 || s.Lost != 0 {
+		t.Errorf("negative index changed the MultiDecoder: %v", s)
+	}
+}
@@ -0,0 +1,149 @@
+package riblt
+
+import (
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// parallelMinSymbols is the smallest number of pure coded symbols for which a
+// round of TryDecodeParallel peels in parallel. Smaller rounds are peeled
+// serially, since starting the workers would cost more than it saves.
+const parallelMinSymbols = 256
+
+// peelUpdate is the peeling of source symbol t off coded symbol cidx.
+type peelUpdate struct {
+	cidx uint32
+	t    HashType
+}
+
+// TryDecodeParallel is like TryDecode, but peels with the given number of
+// workers, or GOMAXPROCS workers if workers is not positive. It decodes in
+// rounds: each round takes the source symbols of all the coded symbols that
+// are pure at its start, computes their mappings in parallel, and then peels
+// them off in parallel, with each worker owning an interleaved share of the
+// coded symbols, so that no two workers update the same coded symbol. The
+// result is the same as that of TryDecode, except that Remote may list the
+// source symbols in a different order, and that on ErrNotSubset, the source
+// symbols of the round that found the coded symbol of negative degree are not
+// peeled.
+func (d *Decoder) TryDecodeParallel(workers int) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers == 1 {
+		return d.TryDecode()
+	}
+	// buckets[w][p] are the updates found by worker w for coded symbols
+	// owned by worker p, i.e., whose indices are p modulo workers
+	buckets := make([][][]peelUpdate, workers)
+	for w := range buckets {
+		buckets[w] = make([][]peelUpdate, workers)
+	}
+	decodable := make([][]int, workers)
+	var mappings []randomMapping
+	var wg sync.WaitGroup
+	for len(d.decodable) > 0 {
+		entries := d.decodable
+		var symbols []HashType
+		for _, cidx := range entries {
+			switch c := d.cs[cidx]; {
+			case c.Count == 1:
+				symbols = append(symbols, c.Hash)
+			case c.Count < 0:
+				// Leave the round in the decodable list, as TryDecode does,
+				// so that the next call finds the same coded symbol.
+				return ErrNotSubset
+			}
+		}
+		d.decodable = nil
+		// Every entry counts as decoded, as in TryDecode: those of degree 1
+		// are peeled now, and those of degree 0 were peeled already.
+		d.decoded += len(entries)
+		// A source symbol may be the only one left in several coded symbols.
+		slices.Sort(symbols)
+		symbols = slices.Compact(symbols)
+		if len(symbols) < parallelMinSymbols {
+			for _, t := range symbols {
+				m := d.applyNewSymbol(t, remove)
+				d.remote.addHashWithMapping(t, m)
+			}
+			continue
+		}
+
+		// Compute the mappings, and sort the updates by owner.
+		mappings = slices.Grow(mappings[:0], len(symbols))[:len(symbols)]
+		mp := d.window.mapping
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				own := buckets[w]
+				for p := range own {
+					own[p] = own[p][:0]
+				}
+				for i := w * len(symbols) / workers; i < (w+1)*len(symbols)/workers; i++ {
+					t := symbols[i]
+					m := newMapping(mp, t)
+					for int(m.lastIdx) < len(d.cs) {
+						cidx := int(m.lastIdx)
+						p := cidx % workers
+						own[p] = append(own[p], peelUpdate{uint32(cidx), t})
+						m.next(mp)
+					}
+					mappings[i] = m
+				}
+			}(w)
+		}
+		wg.Wait()
+
+		// Apply the updates. As in applyNewSymbol, a coded symbol becomes
+		// decodable when an update leaves it with degree 1 (or -1).
+		for p := 0; p < workers; p++ {
+			wg.Add(1)
+			go func(p int) {
+				defer wg.Done()
+				found := decodable[p][:0]
+				for w := 0; w < workers; w++ {
+					for _, u := range buckets[w][p] {
+						c := &d.cs[u.cidx]
+						*c = c.apply(u.t, remove)
+						lost := d.lost != nil && d.lost[u.cidx]
+						if !lost && (c.Count == -1 || c.Count == 1) {
+							found = append(found, int(u.cidx))
+						}
+					}
+				}
+				decodable[p] = found
+			}(p)
+		}
+		wg.Wait()
+
+		for p := range decodable {
+			d.decodable = append(d.decodable, decodable[p]...)
+		}
+		for i, t := range symbols {
+			d.remote.addHashWithMapping(t, mappings[i])
+		}
+	}
+	d.decodable = d.decodable[:0]
+	return nil
+}
+
+// DecodeParallel is like Decode, but decodes with TryDecodeParallel and the
+// given number of workers.
+func (s Sketch) DecodeParallel(workers int) (fwd []HashType, rev []HashType, succ bool) {
+	return s.DecodeParallelWithMapping(workers, nil)
+}
+
+// DecodeParallelWithMapping is like DecodeParallel, for a sketch whose source
+// symbols were mapped to coded symbols using mp.
+func (s Sketch) DecodeParallelWithMapping(workers int, mp Mapping) (fwd []HashType, rev []HashType, succ bool) {
+	dec := Decoder{}
+	dec.SetMapping(mp)
+	for _, c := range s {
+		dec.AddCodedSymbol(c)
+	}
+	dec.TryDecodeParallel(workers)
+	return dec.Remote(), dec.Local(), dec.Decoded()
+}
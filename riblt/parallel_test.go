@@ -0,0 +1,117 @@
+package riblt
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestTryDecodeParallel(t *testing.T) {
+	cases := []struct {
+		name    string
+		nremote int
+		mapping Mapping
+		loss    int // lose every loss-th coded symbol, if positive
+		batch   int // coded symbols between calls to TryDecode
+	}{
+		{"small", 10, nil, 0, 1},
+		{"large", 5000, nil, 0, 1 << 20},
+		{"batches", 5000, nil, 0, 500},
+		{"key", 5000, Key{1}, 0, 1000},
+		{"loss", 5000, nil, 10, 1 << 20},
+	}
+	for _, tc := range cases {
+		for _, workers := range []int{2, 3, 8} {
+			enc, serial, remote := newTestSets(tc.nremote, 1000)
+			_, parallel, _ := newTestSets(tc.nremote, 1000)
+			if tc.mapping != nil {
+				enc.SetMapping(tc.mapping)
+				serial.SetMapping(tc.mapping)
+				parallel.SetMapping(tc.mapping)
+			}
+			name := tc.name + "/workers=" + strconv.Itoa(workers)
+			for i := 0; !serial.Decoded() || len(serial.Remote()) != tc.nremote; i++ {
+				c := enc.ProduceNextCodedSymbol()
+				if tc.loss > 0 && i%tc.loss == 0 {
+					continue
+				}
+				serial.AddCodedSymbolAt(i, c)
+				parallel.AddCodedSymbolAt(i, c)
+				if (i+1)%tc.batch == 0 || i+1 >= tc.nremote*2 {
+					serial.TryDecode()
+					parallel.TryDecodeParallel(workers)
+					if serial.Stats() != parallel.Stats() || !slices.Equal(serial.cs, parallel.cs) {
+						t.Fatalf("%s: diverged after %d coded symbols: %v, expected %v", name, i+1, parallel.Stats(), serial.Stats())
+					}
+				}
+			}
+			checkRemote(t, parallel, remote)
+			// subsequent coded symbols are peeled alike
+			c := enc.ProduceNextCodedSymbol()
+			serial.AddCodedSymbol(c)
+			parallel.AddCodedSymbol(c)
+			if serial.cs[len(serial.cs)-1] != parallel.cs[len(parallel.cs)-1] {
+				t.Errorf("%s: peeled the next coded symbol differently", name)
+			}
+		}
+	}
+}
+
+func TestSketchDecodeParallel(t *testing.T) {
+	s := make(Sketch, 3000)
+	for i := 0; i < 2000; i++ {
+		s.AddSymbol(newTestSymbol(uint64(i)).Hash())
+	}
+	want, _, wantSucc := s.Decode()
+	got, rev, succ := s.DecodeParallel(4)
+	slices.Sort(want)
+	slices.Sort(got)
+	if succ != wantSucc || !succ || !slices.Equal(got, want) || len(rev) != 0 {
+		t.Errorf("decoded %d symbols %t, expected %d %t", len(got), succ, len(want), wantSucc)
+	}
+}
+
+func TestTryDecodeParallelNotSubset(t *testing.T) {
+	enc, dec, _ := newTestSets(2000, 1000)
+	for i := uint64(0); i < 10; i++ {
+		dec.AddSymbol(newTestSymbol(1000000 + i).Hash())
+	}
+	for i := 0; i < 4000; i++ {
+		dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+	}
+	if err := dec.TryDecodeParallel(4); err != ErrNotSubset {
+		t.Fatalf("TryDecodeParallel returned %v, expected ErrNotSubset", err)
+	}
+	if err := dec.TryDecode(); err != ErrNotSubset || dec.Decoded() {
+		t.Errorf("TryDecode returned %v after failing", err)
+	}
+}
+
+func BenchmarkDecodeParallel(bc *testing.B) {
+	cases := []struct {
+		name string
+		size int
+	}{
+		{"d=50000", 50000},
+		{"d=100000", 100000},
+	}
+	for _, tc := range cases {
+		// 1.5 coded symbols per difference decode with high probability.
+		// Multiplying by an odd constant keeps the source symbols distinct,
+		// unlike random hashes, which collide among 100000.
+		s := make(Sketch, tc.size*3/2)
+		for i := 0; i < tc.size; i++ {
+			s.AddSymbol(HashType(i) * 0x9e3779b1)
+		}
+		for _, workers := range []int{1, 2, 4, 8} {
+			bc.Run(tc.name+"/workers="+strconv.Itoa(workers), func(b *testing.B) {
+				b.SetBytes(HashTypeSize * int64(tc.size))
+				for i := 0; i < b.N; i++ {
+					if _, _, succ := s.DecodeParallel(workers); !succ {
+						b.Fatal("failed to decode")
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package riblt
+
+// Scheduler selects the data structure an Encoder or a Decoder uses to find
+// the source symbols mapped to the next coded symbol.
+type Scheduler int
+
+const (
+	// HeapScheduler keeps the source symbols in a binary heap ordered by the
+	// next coded symbol they are mapped to. Each mapping costs time
+	// logarithmic to the number of source symbols. It is the default.
+	HeapScheduler Scheduler = iota
+	// CalendarScheduler keeps the source symbols in a calendar queue, i.e.,
+	// in buckets of the coded symbols they are next mapped to, for a window
+	// of coded symbols about as long as the number of source symbols, and
+	// in an overflow list beyond the window. Each mapping costs amortized
+	// constant time, at the cost of about 8 more bytes of memory per source
+	// symbol. It is faster for large sets; see BenchmarkEncoderMemory.
+	CalendarScheduler
+)
+
+// calendarNil terminates the lists of source symbols in a calendarQueue.
+const calendarNil = windowNever
+
+// minCalendarBuckets is the initial number of buckets of a calendarQueue.
+const minCalendarBuckets = 64
+
+// calendarQueue is a calendar queue of source symbols by the next coded
+// symbols they are mapped to. The coded symbols in [base, base+len(heads))
+// each have a bucket, a linked list threaded through next, and so every source
+// symbol in a bucket is mapped to the same coded symbol. The source symbols
+// mapped to coded symbols beyond are in the far list, which is rescanned each
+// time base advances by len(heads), i.e., once every len(heads) coded symbols.
+// len(heads) is a power of two and at least the number of source symbols, so
+// rescanning costs amortized constant time per coded symbol. Source symbols
+// mapped to windowNever are in no list at all.
+type calendarQueue struct {
+	keys  []uint32 // next coded symbol each source symbol is mapped to
+	next  []uint32 // next source symbol in the same list, or calendarNil
+	heads []uint32 // first source symbol in each bucket, or calendarNil
+	far   uint32   // first source symbol in the far list, or calendarNil
+	base  int      // first coded symbol with a bucket, a multiple of len(heads)
+}
+
+// newCalendarQueue returns an empty calendarQueue whose buckets start at
+// coded symbol cur.
+func newCalendarQueue(cur int) *calendarQueue {
+	q := &calendarQueue{}
+	q.reset(cur)
+	return q
+}
+
+// reset clears q, and places the buckets at coded symbol cur.
+func (q *calendarQueue) reset(cur int) {
+	q.keys = q.keys[:0]
+	q.next = q.next[:0]
+	if len(q.heads) == 0 {
+		q.heads = make([]uint32, minCalendarBuckets)
+	}
+	for i := range q.heads {
+		q.heads[i] = calendarNil
+	}
+	q.far = calendarNil
+	q.base = cur &^ (len(q.heads) - 1)
+}
+
+// link inserts source symbol src, mapped to coded symbol key, into the list
+// it belongs to.
+func (q *calendarQueue) link(src uint32, key uint32) {
+	if key == windowNever {
+		return
+	}
+	if int(key) < q.base+len(q.heads) {
+		b := int(key) & (len(q.heads) - 1)
+		q.next[src] = q.heads[b]
+		q.heads[b] = src
+	} else {
+		q.next[src] = q.far
+		q.far = src
+	}
+}
+
+// push inserts the next source symbol, mapped to coded symbol key, which must
+// not be smaller than cur, the next coded symbol to be generated.
+func (q *calendarQueue) push(key uint32, cur int) {
+	src := uint32(len(q.keys))
+	q.keys = append(q.keys, key)
+	q.next = append(q.next, calendarNil)
+	if len(q.keys) > len(q.heads) {
+		q.grow(cur)
+	} else {
+		q.link(src, key)
+	}
+}
+
+// grow doubles the number of buckets, and places them at coded symbol cur.
+func (q *calendarQueue) grow(cur int) {
+	q.heads = make([]uint32, 2*len(q.heads))
+	for i := range q.heads {
+		q.heads[i] = calendarNil
+	}
+	q.far = calendarNil
+	q.base = cur &^ (len(q.heads) - 1)
+	for src, key := range q.keys {
+		q.link(uint32(src), key)
+	}
+}
+
+// take removes and returns the list of source symbols mapped to coded symbol
+// cur. It must be called for each coded symbol in order. The caller must
+// update the keys of the source symbols in the list and link them back.
+func (q *calendarQueue) take(cur int) uint32 {
+	if cur >= q.base+len(q.heads) {
+		q.base += len(q.heads)
+		far := q.far
+		q.far = calendarNil
+		for far != calendarNil {
+			src := far
+			far = q.next[src]
+			q.link(src, q.keys[src])
+		}
+	}
+	b := cur & (len(q.heads) - 1)
+	src := q.heads[b]
+	q.heads[b] = calendarNil
+	return src
+}
+
+// applyCalendar is applyWindow for a codingWindow with a calendarQueue.
+func (e *codingWindow) applyCalendar(cw CodedSymbol, direction int64) CodedSymbol {
+	q := e.calendar
+	for src := q.take(e.nextIdx); src != calendarNil; {
+		next := q.next[src]
+		cw = cw.apply(e.symbols[src], direction)
+		// generate the next mapping
+		m := randomMapping{e.prngs[src], uint64(q.keys[src])}
+		q.keys[src] = windowIndex(m.next(e.mapping))
+		e.prngs[src] = m.prng
+		q.link(src, q.keys[src])
+		src = next
+	}
+	e.nextIdx += 1
+	return cw
+}
+
+// setScheduler makes the codingWindow use scheduler s. It must not be called
+// after applyWindow.
+func (e *codingWindow) setScheduler(s Scheduler) {
+	if e.nextIdx != 0 {
+		panic("setting scheduler after generating coded symbols")
+	}
+	switch s {
+	case HeapScheduler:
+		e.calendar = nil
+	case CalendarScheduler:
+		if e.calendar == nil {
+			e.calendar = newCalendarQueue(0)
+		}
+	default:
+		panic("unknown scheduler")
+	}
+	// rebuild the queue
+	e.setMapping(e.mapping)
+}
@@ -0,0 +1,56 @@
+package riblt
+
+import (
+	"testing"
+)
+
+func TestCalendarScheduler(t *testing.T) {
+	// The coded symbols do not depend on the Scheduler. Cover sets smaller
+	// and larger than the initial number of buckets, and sequences much
+	// longer than the sets, so that the far list is rescanned many times.
+	for _, n := range []int{0, 1, 10, 1000, 20000} {
+		heap := Encoder{}
+		calendar := Encoder{}
+		calendar.SetScheduler(CalendarScheduler)
+		for i := 0; i < n; i++ {
+			s := newTestSymbol(uint64(i)).Hash()
+			heap.AddHash(s)
+			calendar.AddHash(s)
+		}
+		for i := 0; i < 100000; i++ {
+			if a, b := heap.ProduceNextCodedSymbol(), calendar.ProduceNextCodedSymbol(); a != b {
+				t.Fatalf("n=%d: coded symbol %d is %v with the heap, %v with the calendar queue", n, i, a, b)
+			}
+		}
+	}
+}
+
+func TestCalendarSchedulerDecode(t *testing.T) {
+	// The remote set of the Decoder grows while decoding.
+	enc, dec, remote := newTestSets(10000, 1000)
+	enc.SetScheduler(CalendarScheduler)
+	dec.SetScheduler(CalendarScheduler)
+	for i := 0; i == 0 || !dec.Decoded(); i++ {
+		dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+		dec.TryDecode()
+	}
+	checkRemote(t, dec, remote)
+
+	// Reset keeps the Scheduler.
+	dec.Reset()
+	if dec.window.calendar == nil || dec.remote.calendar == nil {
+		t.Errorf("Reset dropped the calendar queue")
+	}
+}
+
+func TestSetSchedulerAfterEncoding(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("SetScheduler did not panic after encoding")
+		}
+	}()
+	enc := Encoder{}
+	enc.AddHash(1)
+	enc.ProduceNextCodedSymbol()
+	enc.SetScheduler(CalendarScheduler)
+}
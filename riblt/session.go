@@ -0,0 +1,500 @@
+package riblt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Serve and Sync implement a session layer that runs the reconciliation loop
+// described in the package documentation over a reliable byte stream. Alice
+// calls Serve with her Encoder, and Bob calls Sync with his Decoder on the two
+// ends of the same connection.
+//
+// The protocol is a sequence of messages, each framed as a one-byte message
+// type, followed by the uvarint-encoded length of the payload and the payload
+// itself. A session proceeds as follows.
+//  0. If SessionOptions.Keyed is set, Bob sends his contribution to the Key
+//     of the session, and Alice replies with hers. See type Key. Then, if
+//     SessionOptions.Checksum is set, Alice sends the Checksum of her set.
+//  1. Bob grants Alice credit for Window batches of coded symbols.
+//  2. Alice sends one batch of BatchSize coded symbols for each unit of
+//     credit she holds. Bob grants one more unit of credit for each batch he
+//     receives, until he decodes the symmetric difference.
+//  3. Bob sends a stop message, and discards batches that were in flight.
+//  4. Alice replies to the stop message with a done message, after which
+//     neither side sends more messages on the connection.
+
+const (
+	msgSymbols byte = iota + 1 // a batch of coded symbols
+	msgCredit                  // permission to send more batches
+	msgStop                    // request to stop sending coded symbols
+	msgDone                    // acknowledgement of msgStop
+	msgKey                     // contribution to the Key of the session
+	msgChecksum                // checksum of the set of the Encoder
+)
+
+const (
+	// DefaultBatchSize is the default number of coded symbols in a batch.
+	DefaultBatchSize = 64
+	// DefaultWindow is the default number of batches in flight.
+	DefaultWindow = 4
+	// maxMessageSize is the largest message payload we accept.
+	maxMessageSize = 1 << 24
+)
+
+var (
+	// ErrProtocol is returned when the peer violates the session protocol.
+	ErrProtocol = errors.New("riblt: session protocol violation")
+	// ErrSymbolLimit is returned by Sync when the Decoder fails to decode
+	// after receiving SessionOptions.MaxSymbols coded symbols.
+	ErrSymbolLimit = errors.New("riblt: coded symbol limit reached")
+)
+
+// SessionOptions configures Serve and Sync. A nil *SessionOptions is
+// equivalent to the zero value, which selects the defaults.
+type SessionOptions struct {
+	// BatchSize is the number of coded symbols Serve puts in one message. It
+	// is ignored by Sync. Defaults to DefaultBatchSize.
+	BatchSize int
+	// Window is the number of batches Sync allows to be in flight. It is
+	// ignored by Serve. Defaults to DefaultWindow.
+	Window int
+	// MaxSymbols is the number of coded symbols after which Sync gives up.
+	// Zero means no limit.
+	MaxSymbols int
+	// Interval is the pause between two datagrams sent by ServeDatagram. It
+	// is ignored by the other functions. Zero means no pause.
+	Interval time.Duration
+	// Keyed makes Serve and Sync negotiate a fresh Key for the session and
+	// set it on the Encoder and the Decoder. It must be set on both sides or
+	// neither. ServeDatagram and SyncDatagram ignore Keyed; a Key may be
+	// negotiated out of band and set with SetKey instead.
+	Keyed bool
+	// Checksum makes Serve send the Checksum of the Encoder, and Sync set it
+	// with Decoder.SetRemoteChecksum, so that Sync only stops once the
+	// recovered set matches it. It must be set on both sides or neither.
+	// ServeDatagram and SyncDatagram ignore Checksum.
+	Checksum bool
+}
+
+func (o *SessionOptions) batchSize() int {
+	if o == nil || o.BatchSize <= 0 {
+		return DefaultBatchSize
+	}
+	return o.BatchSize
+}
+
+func (o *SessionOptions) window() int {
+	if o == nil || o.Window <= 0 {
+		return DefaultWindow
+	}
+	return o.Window
+}
+
+func (o *SessionOptions) maxSymbols() int {
+	if o == nil {
+		return 0
+	}
+	return o.MaxSymbols
+}
+
+func (o *SessionOptions) interval() time.Duration {
+	if o == nil {
+		return 0
+	}
+	return o.Interval
+}
+
+func (o *SessionOptions) keyed() bool {
+	return o != nil && o.Keyed
+}
+
+func (o *SessionOptions) checksum() bool {
+	return o != nil && o.Checksum
+}
+
+// Serve streams the coded symbols of enc over rw until the peer, running Sync,
+// signals that it has decoded the symmetric difference. Serve returns nil in
+// that case.
+//
+// When ctx is done, Serve returns ctx.Err(). If rw has a SetDeadline method,
+// as net.Conn does, Serve uses it to interrupt pending I/O, and otherwise
+// closes rw if it is an io.Closer. If rw has neither, cancellation is only
+// noticed between messages, and a read that is pending when Serve returns
+// completes in the background.
+func Serve(ctx context.Context, rw io.ReadWriter, enc *Encoder, opts *SessionOptions) error {
+	defer watchContext(ctx, rw)()
+	c := newSessionConn(rw)
+
+	if opts.keyed() {
+		pk, err := c.readKey()
+		if err != nil {
+			return sessionError(ctx, err)
+		}
+		k, err := c.writeKey()
+		if err != nil {
+			return sessionError(ctx, err)
+		}
+		enc.SetKey(DeriveKey(k, pk))
+	}
+	if opts.checksum() {
+		c.beginMessage(msgChecksum)
+		c.buf = binary.LittleEndian.AppendUint64(c.buf, enc.Checksum())
+		if err := c.endMessage(); err != nil {
+			return sessionError(ctx, err)
+		}
+	}
+
+	// The reader goroutine records incoming messages in the shared state
+	// below, so that the loop that follows is the only writer to rw. The
+	// reader must never wait for the writer: on a synchronous connection the
+	// peer may be blocked writing credit while we are blocked writing a batch.
+	var (
+		mu      sync.Mutex
+		credit  int
+		stopped bool
+		readErr error
+	)
+	wake := make(chan struct{}, 1)
+	readerDone := make(chan struct{})
+	defer func() {
+		// Do not leave the reader blocked on rw after we return because ctx
+		// is done or a write failed, if we can interrupt it. After the stop
+		// message or a read error, the reader exits on its own, and we wait
+		// for it below instead, so that a clean session leaves rw usable.
+		select {
+		case <-readerDone:
+		default:
+			if interrupt := interrupter(rw); interrupt != nil {
+				interrupt()
+				<-readerDone
+			}
+		}
+	}()
+	go func() {
+		defer close(readerDone)
+		for {
+			typ, payload, err := c.readMessage()
+			mu.Lock()
+			if err == nil {
+				switch typ {
+				case msgCredit:
+					var n uint64
+					n, err = readUvarint(payload)
+					if err == nil && n > uint64(math.MaxInt-credit) {
+						err = fmt.Errorf("%w: credit overflow", ErrProtocol)
+					}
+					if err == nil {
+						credit += int(n)
+					}
+				case msgStop:
+					stopped = true
+				default:
+					err = fmt.Errorf("%w: unexpected message type %d", ErrProtocol, typ)
+				}
+			}
+			readErr = err
+			mu.Unlock()
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+			if err != nil || typ == msgStop {
+				return
+			}
+		}
+	}()
+
+	batchSize := opts.batchSize()
+	for {
+		mu.Lock()
+		canSend, stop, err := credit > 0, stopped, readErr
+		if canSend {
+			credit -= 1
+		}
+		mu.Unlock()
+
+		switch {
+		case stop:
+			<-readerDone
+			c.beginMessage(msgDone)
+			return sessionError(ctx, c.endMessage())
+		case err != nil:
+			<-readerDone
+			return sessionError(ctx, err)
+		case canSend:
+			c.beginMessage(msgSymbols)
+			for i := 0; i < batchSize; i++ {
+				c.buf = appendCodedSymbol(c.buf, enc.ProduceNextCodedSymbol())
+			}
+			if err := c.endMessage(); err != nil {
+				return sessionError(ctx, err)
+			}
+		default:
+			select {
+			case <-wake:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// Sync receives coded symbols over rw from a peer running Serve, and passes
+// them to dec until dec decodes the symmetric difference. Sync returns nil
+// when dec.Decoded() is true, at which point dec.Remote() and dec.Local()
+// hold the result. If dec finds that its local set is not a subset of the
+// peer's, Sync stops the peer and returns ErrNotSubset. The Decoder's local
+// set must be fully imported before calling Sync.
+//
+// Context cancellation behaves as in Serve.
+func Sync(ctx context.Context, rw io.ReadWriter, dec *Decoder, opts *SessionOptions) error {
+	defer watchContext(ctx, rw)()
+	c := newSessionConn(rw)
+
+	if opts.keyed() {
+		// Wait for the reply before granting credit, as Serve only starts
+		// reading concurrently with writing after the key is set.
+		k, err := c.writeKey()
+		if err != nil {
+			return sessionError(ctx, err)
+		}
+		pk, err := c.readKey()
+		if err != nil {
+			return sessionError(ctx, err)
+		}
+		dec.SetKey(DeriveKey(pk, k))
+	}
+	if opts.checksum() {
+		typ, payload, err := c.readMessage()
+		if err != nil {
+			return sessionError(ctx, err)
+		}
+		if typ != msgChecksum || len(payload) != 8 {
+			return fmt.Errorf("%w: expected checksum", ErrProtocol)
+		}
+		dec.SetRemoteChecksum(binary.LittleEndian.Uint64(payload))
+	}
+	if err := c.writeCredit(opts.window()); err != nil {
+		return sessionError(ctx, err)
+	}
+	maxSymbols := opts.maxSymbols()
+	received := 0
+	var decodeErr error
+	for {
+		typ, payload, err := c.readMessage()
+		if err != nil {
+			return sessionError(ctx, err)
+		}
+		if typ != msgSymbols {
+			return fmt.Errorf("%w: unexpected message type %d", ErrProtocol, typ)
+		}
+		for len(payload) != 0 {
+			var s CodedSymbol
+			s, payload, err = readCodedSymbol(payload)
+			if err != nil {
+				return err
+			}
+			dec.AddCodedSymbol(s)
+			received += 1
+		}
+		if err := dec.TryDecode(); err != nil {
+			// Still stop the peer cleanly, since the session itself is
+			// fine, before reporting that the sets cannot be reconciled.
+			decodeErr = err
+			break
+		}
+		if dec.Decoded() {
+			break
+		}
+		if maxSymbols > 0 && received >= maxSymbols {
+			return ErrSymbolLimit
+		}
+		if err := c.writeCredit(1); err != nil {
+			return sessionError(ctx, err)
+		}
+	}
+
+	// Ask the peer to stop, and drain the batches that are still in flight.
+	c.beginMessage(msgStop)
+	if err := c.endMessage(); err != nil {
+		return sessionError(ctx, err)
+	}
+	for {
+		typ, _, err := c.readMessage()
+		if err != nil {
+			return sessionError(ctx, err)
+		}
+		switch typ {
+		case msgDone:
+			return decodeErr
+		case msgSymbols:
+		default:
+			return fmt.Errorf("%w: unexpected message type %d", ErrProtocol, typ)
+		}
+	}
+}
+
+// sessionConn frames messages over a byte stream.
+type sessionConn struct {
+	w   io.Writer
+	r   *bufio.Reader
+	buf []byte // message being composed
+	hdr int    // length of the header reserved at the front of buf
+}
+
+func newSessionConn(rw io.ReadWriter) *sessionConn {
+	return &sessionConn{w: rw, r: bufio.NewReader(rw)}
+}
+
+// beginMessage starts composing a message of type typ. The payload should be
+// appended to c.buf, and the message sent by calling endMessage.
+func (c *sessionConn) beginMessage(typ byte) {
+	// Reserve room for the type and the longest possible length prefix, so
+	// that the header can be filled in without moving the payload.
+	c.hdr = 1 + binary.MaxVarintLen64
+	c.buf = append(c.buf[:0], make([]byte, c.hdr)...)
+	c.buf[0] = typ
+}
+
+// endMessage writes the message composed since the last call to beginMessage.
+func (c *sessionConn) endMessage() error {
+	var lbuf [binary.MaxVarintLen64]byte
+	l := binary.PutUvarint(lbuf[:], uint64(len(c.buf)-c.hdr))
+	start := c.hdr - l - 1
+	c.buf[start] = c.buf[0]
+	copy(c.buf[start+1:], lbuf[:l])
+	_, err := c.w.Write(c.buf[start:])
+	return err
+}
+
+func (c *sessionConn) writeCredit(n int) error {
+	c.beginMessage(msgCredit)
+	c.buf = binary.AppendUvarint(c.buf, uint64(n))
+	return c.endMessage()
+}
+
+// writeKey generates and sends our contribution to the Key of the session.
+func (c *sessionConn) writeKey() (Key, error) {
+	k, err := NewKey()
+	if err != nil {
+		return k, err
+	}
+	c.beginMessage(msgKey)
+	c.buf = append(c.buf, k[:]...)
+	return k, c.endMessage()
+}
+
+// readKey receives the contribution of the peer to the Key of the session.
+func (c *sessionConn) readKey() (Key, error) {
+	var k Key
+	typ, payload, err := c.readMessage()
+	if err != nil {
+		return k, err
+	}
+	if typ != msgKey || len(payload) != len(k) {
+		return k, fmt.Errorf("%w: expected key negotiation", ErrProtocol)
+	}
+	copy(k[:], payload)
+	return k, nil
+}
+
+// readMessage reads the next message. The returned payload is only valid
+// until the next call to readMessage.
+func (c *sessionConn) readMessage() (typ byte, payload []byte, err error) {
+	typ, err = c.r.ReadByte()
+	if err != nil {
+		// Every session ends with an explicit message, so the stream never
+		// ends cleanly between messages either.
+		return 0, nil, unexpectedEOF(err)
+	}
+	l, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	if l > maxMessageSize {
+		return 0, nil, fmt.Errorf("%w: message of %d bytes", ErrProtocol, l)
+	}
+	payload = make([]byte, l)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	return typ, payload, nil
+}
+
+// appendCodedSymbol appends the wire encoding of s to b. The hash is encoded
+// in little endian, and the count as a zigzag varint.
+func appendCodedSymbol(b []byte, s CodedSymbol) []byte {
+	b = binary.LittleEndian.AppendUint32(b, s.Hash)
+	return binary.AppendVarint(b, s.Count)
+}
+
+// readCodedSymbol decodes a coded symbol encoded by appendCodedSymbol from the
+// front of b, and returns the rest of b.
+func readCodedSymbol(b []byte) (CodedSymbol, []byte, error) {
+	if len(b) < int(HashTypeSize) {
+		return CodedSymbol{}, nil, fmt.Errorf("%w: truncated coded symbol", ErrProtocol)
+	}
+	s := CodedSymbol{Hash: binary.LittleEndian.Uint32(b)}
+	b = b[HashTypeSize:]
+	count, n := binary.Varint(b)
+	if n <= 0 {
+		return CodedSymbol{}, nil, fmt.Errorf("%w: malformed coded symbol count", ErrProtocol)
+	}
+	s.Count = count
+	return s, b[n:], nil
+}
+
+func readUvarint(b []byte) (uint64, error) {
+	v, n := binary.Uvarint(b)
+	if n <= 0 || n != len(b) {
+		return 0, fmt.Errorf("%w: malformed varint", ErrProtocol)
+	}
+	return v, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// sessionError returns ctx.Err() if ctx is done, as any I/O error was then
+// most likely caused by watchContext, and err otherwise.
+func sessionError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// interrupter returns a function that makes pending and future I/O on rw
+// fail, using the SetDeadline method of rw if it has one, and its Close method
+// otherwise. It returns nil if rw has neither.
+func interrupter(rw io.ReadWriter) func() {
+	switch rw := rw.(type) {
+	case interface{ SetDeadline(time.Time) error }:
+		return func() { rw.SetDeadline(time.Unix(1, 0)) }
+	case io.Closer:
+		return func() { rw.Close() }
+	}
+	return nil
+}
+
+// watchContext interrupts pending I/O on rw when ctx is done, if rw can be
+// interrupted. It returns a function that stops watching.
+func watchContext(ctx context.Context, rw io.ReadWriter) func() bool {
+	interrupt := interrupter(rw)
+	if interrupt == nil {
+		return func() bool { return false }
+	}
+	return context.AfterFunc(ctx, interrupt)
+}
@@ -0,0 +1,334 @@
+package riblt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestSets returns an Encoder holding nremote+ncommon symbols and a Decoder
+// holding ncommon of them, along with the symbols exclusive to the Encoder.
+func newTestSets(nremote, ncommon int) (*Encoder, *Decoder, map[HashType]struct{}) {
+	enc := &Encoder{}
+	dec := &Decoder{}
+	remote := make(map[HashType]struct{})
+	var nextId uint64
+	for i := 0; i < nremote; i++ {
+		s := newTestSymbol(nextId)
+		nextId += 1
+		enc.AddSymbol(s.Hash())
+		remote[s.Hash()] = struct{}{}
+	}
+	for i := 0; i < ncommon; i++ {
+		s := newTestSymbol(nextId)
+		nextId += 1
+		enc.AddSymbol(s.Hash())
+		dec.AddSymbol(s.Hash())
+	}
+	return enc, dec, remote
+}
+
+func checkRemote(t *testing.T, dec *Decoder, remote map[HashType]struct{}) {
+	t.Helper()
+	if !dec.Decoded() {
+		t.Errorf("decoder not marked as decoded")
+	}
+	if len(dec.Remote()) != len(remote) {
+		t.Errorf("decoded %d remote symbols, expected %d", len(dec.Remote()), len(remote))
+	}
+	for _, v := range dec.Remote() {
+		if _, ok := remote[v]; !ok {
+			t.Errorf("decoded symbol %d not in remote set", v)
+		}
+	}
+	if len(dec.Local()) != 0 {
+		t.Errorf("decoded %d local symbols, expected 0", len(dec.Local()))
+	}
+}
+
+// runSession runs Serve and Sync on the two ends of a net.Pipe.
+func runSession(t *testing.T, enc *Encoder, dec *Decoder, serveOpts, syncOpts *SessionOptions) (serveErr, syncErr error) {
+	t.Helper()
+	alice, bob := net.Pipe()
+	defer alice.Close()
+	defer bob.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- Serve(ctx, alice, enc, serveOpts)
+	}()
+	syncErr = Sync(ctx, bob, dec, syncOpts)
+	serveErr = <-done
+	return serveErr, syncErr
+}
+
+func TestSession(t *testing.T) {
+	cases := []struct {
+		name    string
+		nremote int
+		opts    *SessionOptions
+	}{
+		{"default", 1000, nil},
+		{"empty", 0, nil},
+		{"batch=1", 100, &SessionOptions{BatchSize: 1, Window: 1}},
+		{"batch=7", 1000, &SessionOptions{BatchSize: 7, Window: 16}},
+		{"batch=1000", 10, &SessionOptions{BatchSize: 1000, Window: 2}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			enc, dec, remote := newTestSets(tc.nremote, 1000)
+			serveErr, syncErr := runSession(t, enc, dec, tc.opts, tc.opts)
+			if serveErr != nil {
+				t.Errorf("Serve: %v", serveErr)
+			}
+			if syncErr != nil {
+				t.Errorf("Sync: %v", syncErr)
+			}
+			checkRemote(t, dec, remote)
+		})
+	}
+}
+
+func TestSessionReuseConn(t *testing.T) {
+	// Unlike net.Pipe, TCP does not wait for the peer to read, so Serve may
+	// return right after writing the done message.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on loopback: %v", err)
+	}
+	defer l.Close()
+	bob, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.Close()
+	alice, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// A clean session must leave the connection usable, e.g., for the next
+	// session.
+	for i := 0; i < 300; i++ {
+		enc, dec, remote := newTestSets(10, 10)
+		done := make(chan error)
+		go func() {
+			done <- Serve(ctx, alice, enc, nil)
+		}()
+		if err := Sync(ctx, bob, dec, nil); err != nil {
+			t.Fatalf("session %d: Sync: %v", i, err)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("session %d: Serve: %v", i, err)
+		}
+		checkRemote(t, dec, remote)
+	}
+	go alice.Write([]byte{1})
+	var buf [1]byte
+	if _, err := bob.Read(buf[:]); err != nil {
+		t.Errorf("connection unusable after the sessions: %v", err)
+	}
+}
+
+func TestSessionSymbolLimit(t *testing.T) {
+	enc, dec, _ := newTestSets(1000, 1000)
+	opts := &SessionOptions{BatchSize: 10, MaxSymbols: 100}
+	alice, bob := net.Pipe()
+	defer alice.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- Serve(ctx, alice, enc, opts)
+	}()
+	if err := Sync(ctx, bob, dec, opts); !errors.Is(err, ErrSymbolLimit) {
+		t.Errorf("Sync returned %v, expected ErrSymbolLimit", err)
+	}
+	// Sync gives up without a stop message, so Serve ends when the connection
+	// goes away.
+	bob.Close()
+	if err := <-done; err == nil {
+		t.Errorf("Serve returned nil after the peer went away")
+	}
+}
+
+func TestSessionCancel(t *testing.T) {
+	enc, dec, _ := newTestSets(1000, 1000)
+	alice, bob := net.Pipe()
+	defer alice.Close()
+	defer bob.Close()
+
+	// Nobody is at the other end of either pipe, so both calls block until
+	// the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	serveDone := make(chan error)
+	syncDone := make(chan error)
+	go func() {
+		serveDone <- Serve(ctx, alice, enc, nil)
+	}()
+	go func() {
+		syncDone <- Sync(ctx, bob, dec, nil)
+	}()
+	cancel()
+	for _, done := range []chan error{serveDone, syncDone} {
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("returned %v, expected context.Canceled", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("did not return after cancellation")
+		}
+	}
+}
+
+// pipeConn is one end of a pair of io.Pipes. It has a Close method, but no
+// SetDeadline method.
+type pipeConn struct {
+	*io.PipeReader
+	*io.PipeWriter
+}
+
+func (c pipeConn) Close() error {
+	c.PipeReader.Close()
+	return c.PipeWriter.Close()
+}
+
+func TestSessionCancelCloser(t *testing.T) {
+	enc, _, _ := newTestSets(10, 10)
+	r, w := io.Pipe()
+	_, w2 := io.Pipe()
+	alice := pipeConn{r, w2}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Serve(ctx, alice, enc, nil)
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Serve returned %v, expected context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Serve did not return after cancellation")
+	}
+	// Serve closed its end, which is how its reader got unblocked.
+	if _, err := w.Write([]byte{msgStop, 0}); err != io.ErrClosedPipe {
+		t.Errorf("write to the canceled session returned %v", err)
+	}
+}
+
+func TestSessionNotSubset(t *testing.T) {
+	enc, dec, _ := newTestSets(100, 1000)
+	for i := uint64(0); i < 10; i++ {
+		dec.AddSymbol(newTestSymbol(1000000 + i).Hash())
+	}
+	serveErr, syncErr := runSession(t, enc, dec, nil, nil)
+	if serveErr != nil {
+		t.Errorf("Serve: %v", serveErr)
+	}
+	if !errors.Is(syncErr, ErrNotSubset) {
+		t.Errorf("Sync returned %v, expected ErrNotSubset", syncErr)
+	}
+	if dec.Decoded() {
+		t.Errorf("decoder marked as decoded")
+	}
+	if err := dec.TryDecode(); err != ErrNotSubset {
+		t.Errorf("TryDecode returned %v after failing", err)
+	}
+}
+
+func TestSessionProtocolViolation(t *testing.T) {
+	_, dec, _ := newTestSets(10, 10)
+	alice, bob := net.Pipe()
+	defer alice.Close()
+	defer bob.Close()
+
+	go func() {
+		c := newSessionConn(alice)
+		c.readMessage()
+		c.beginMessage(msgCredit)
+		c.buf = append(c.buf, 1)
+		c.endMessage()
+	}()
+	if err := Sync(context.Background(), bob, dec, nil); !errors.Is(err, ErrProtocol) {
+		t.Errorf("Sync returned %v, expected ErrProtocol", err)
+	}
+
+	// Credit that would overflow
+	enc, _, _ := newTestSets(10, 10)
+	alice2, bob2 := net.Pipe()
+	defer alice2.Close()
+	defer bob2.Close()
+	go func() {
+		c := newSessionConn(bob2)
+		c.writeCredit(math.MaxInt)
+		c.writeCredit(math.MaxInt)
+		for {
+			if _, _, err := c.readMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	if err := Serve(context.Background(), alice2, enc, &SessionOptions{BatchSize: 1}); !errors.Is(err, ErrProtocol) {
+		t.Errorf("Serve returned %v, expected ErrProtocol", err)
+	}
+}
+
+func TestCodedSymbolEncoding(t *testing.T) {
+	symbols := []CodedSymbol{
+		{0, 0},
+		{1, 1},
+		{0xffffffff, -1},
+		{0x12345678, 1 << 40},
+		{0x9abcdef0, -(1 << 40)},
+	}
+	var b []byte
+	for _, s := range symbols {
+		b = appendCodedSymbol(b, s)
+	}
+	for _, s := range symbols {
+		var got CodedSymbol
+		var err error
+		got, b, err = readCodedSymbol(b)
+		if err != nil {
+			t.Fatalf("readCodedSymbol: %v", err)
+		}
+		if got != s {
+			t.Errorf("decoded %v, expected %v", got, s)
+		}
+	}
+	if len(b) != 0 {
+		t.Errorf("%d trailing bytes", len(b))
+	}
+	if _, _, err := readCodedSymbol([]byte{1, 2, 3}); !errors.Is(err, ErrProtocol) {
+		t.Errorf("truncated symbol returned %v, expected ErrProtocol", err)
+	}
+}
+
+func TestChecksumSession(t *testing.T) {
+	enc, dec, remote := newTestSets(1000, 1000)
+	opts := &SessionOptions{Keyed: true, Checksum: true}
+	dec.SetConfirmation(8)
+	serveErr, syncErr := runSession(t, enc, dec, opts, opts)
+	if serveErr != nil {
+		t.Errorf("Serve: %v", serveErr)
+	}
+	if syncErr != nil {
+		t.Errorf("Sync: %v", syncErr)
+	}
+	checkRemote(t, dec, remote)
+	if !dec.hasChecksum || dec.checksum != enc.Checksum() {
+		t.Errorf("Sync did not set the checksum of the Encoder")
+	}
+}
@@ -9,23 +9,36 @@ type Sketch []CodedSymbol
 
 // AddSymbol inserts source symbol t to the set of which s is a sketch.
 func (s Sketch) AddSymbol(t HashType) {
-	m := randomMapping{t, 0}
+	s.AddSymbolWithMapping(t, nil)
+}
+
+// AddSymbolWithMapping is like AddSymbol, but maps t to coded symbols using
+// mp. See type Mapping. All source symbols of a Sketch must be inserted with
+// the same Mapping.
+func (s Sketch) AddSymbolWithMapping(t HashType, mp Mapping) {
+	m := newMapping(mp, t)
 	for int(m.lastIdx) < len(s) {
 		idx := m.lastIdx
 		s[idx].Count += 1
 		s[idx].Hash ^= t
-		m.nextIndex()
+		m.next(mp)
 	}
 }
 
 // RemoveSymbol deletes source symbol t from the set of which s is a sketch.
 func (s Sketch) RemoveSymbol(t HashType) {
-	m := randomMapping{t, 0}
+	s.RemoveSymbolWithMapping(t, nil)
+}
+
+// RemoveSymbolWithMapping is like RemoveSymbol, but maps t to coded symbols
+// using mp.
+func (s Sketch) RemoveSymbolWithMapping(t HashType, mp Mapping) {
+	m := newMapping(mp, t)
 	for int(m.lastIdx) < len(s) {
 		idx := m.lastIdx
 		s[idx].Count -= 1
 		s[idx].Hash ^= t
-		m.nextIndex()
+		m.next(mp)
 	}
 }
 
@@ -53,7 +66,14 @@ func (s Sketch) Subtract(s2 Sketch) {
 // symbols in S in case 1, or S \ S2 in case 2 (\ is the set subtraction
 // operation). rev is empty in case 1, or S2 \ S in case 2.
 func (s Sketch) Decode() (fwd []HashType, rev []HashType, succ bool) {
+	return s.DecodeWithMapping(nil)
+}
+
+// DecodeWithMapping is like Decode, for a sketch whose source symbols were
+// mapped to coded symbols using mp.
+func (s Sketch) DecodeWithMapping(mp Mapping) (fwd []HashType, rev []HashType, succ bool) {
 	dec := Decoder{}
+	dec.SetMapping(mp)
 	for _, c := range s {
 		dec.AddCodedSymbol(c)
 	}
@@ -0,0 +1,449 @@
+package riblt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+)
+
+// snapshotVersion is the version of the snapshot format, in the first byte of
+// every snapshot.
+const snapshotVersion = 1
+
+// Kinds of snapshots, in the second byte of every snapshot.
+const (
+	snapshotEncoder      = 'E'
+	snapshotDecoder      = 'D'
+	snapshotMultiDecoder = 'M'
+)
+
+// ErrInvalidSnapshot is returned by Restore when the snapshot is corrupt, of
+// a different version or kind of object, or taken with a different Mapping.
+var ErrInvalidSnapshot = errors.New("riblt: invalid snapshot")
+
+// mappingFingerprint returns a digest of mp, to tell apart snapshots taken
+// with different Mappings. A Key is identified by a pseudorandom function of
+// the Key, which does not reveal it. Other Mappings are identified by their
+// type and by the first indices they map a number of source symbols to.
+func mappingFingerprint(mp Mapping) uint64 {
+	switch k := mp.(type) {
+	case Key:
+		return k.fingerprint()
+	case *Key:
+		return k.fingerprint()
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%T", mp)
+	for i := 0; i < 64; i++ {
+		m := newMapping(mp, HashType(i)*0x9e3779b9)
+		for j := 0; j < 16; j++ {
+			h.Write(binary.LittleEndian.AppendUint64(nil, m.lastIdx))
+			m.next(mp)
+		}
+	}
+	return h.Sum64()
+}
+
+// appendSnapshotHeader appends the header of a snapshot of the given kind,
+// for an object with Mapping mp.
+func appendSnapshotHeader(b []byte, kind byte, mp Mapping) []byte {
+	b = append(b, snapshotVersion, kind)
+	return binary.LittleEndian.AppendUint64(b, mappingFingerprint(mp))
+}
+
+// appendSnapshot appends the state of e to b. Each source symbol is encoded
+// as its hash, the PRNG state of its mapping generator, and the next coded
+// symbol it is mapped to.
+func (e *codingWindow) appendSnapshot(b []byte) []byte {
+	keys := make([]uint32, len(e.symbols))
+	if e.calendar != nil {
+		copy(keys, e.calendar.keys)
+	} else {
+		for _, m := range e.queue {
+			keys[m.sourceIdx] = m.codedIdx
+		}
+	}
+	b = binary.AppendUvarint(b, uint64(e.nextIdx))
+	b = binary.AppendUvarint(b, uint64(len(e.symbols)))
+	for i, t := range e.symbols {
+		b = binary.LittleEndian.AppendUint32(b, t)
+		b = binary.LittleEndian.AppendUint32(b, e.prngs[i])
+		b = binary.AppendUvarint(b, uint64(keys[i]))
+	}
+	return b
+}
+
+// windowSnapshot is the parsed state of a codingWindow.
+type windowSnapshot struct {
+	nextIdx int
+	symbols []HashType
+	prngs   []HashType
+	keys    []uint32
+}
+
+// restore replaces the source symbols of e with those in s, keeping the
+// Mapping and the Scheduler of e.
+func (e *codingWindow) restore(s windowSnapshot) {
+	e.reset()
+	e.nextIdx = s.nextIdx
+	if e.calendar != nil {
+		e.calendar.reset(s.nextIdx)
+	}
+	for i, t := range s.symbols {
+		e.addHashWithMapping(t, randomMapping{s.prngs[i], uint64(s.keys[i])})
+	}
+}
+
+// snapshotReader parses a snapshot. The first error is sticky, and turns
+// every later read into a no-op returning zero.
+type snapshotReader struct {
+	b   []byte
+	err error
+}
+
+func (r *snapshotReader) fail(format string, args ...any) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: "+format, append([]any{ErrInvalidSnapshot}, args...)...)
+	}
+}
+
+func (r *snapshotReader) byte() byte {
+	if r.err != nil || len(r.b) < 1 {
+		r.fail("truncated")
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *snapshotReader) uint32() uint32 {
+	if r.err != nil || len(r.b) < 4 {
+		r.fail("truncated")
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *snapshotReader) uint64() uint64 {
+	if r.err != nil || len(r.b) < 8 {
+		r.fail("truncated")
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(r.b)
+	r.b = r.b[8:]
+	return v
+}
+
+func (r *snapshotReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.fail("malformed varint")
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *snapshotReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.fail("malformed varint")
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+// length reads the length of a list whose items take at least size bytes
+// each, and checks that it is plausible, so that a corrupt snapshot cannot
+// make us allocate a lot of memory.
+func (r *snapshotReader) length(size int) int {
+	n := r.uvarint()
+	if n > uint64(len(r.b)/size) {
+		r.fail("list of %d items exceeds snapshot", n)
+		return 0
+	}
+	return int(n)
+}
+
+// header checks the header of a snapshot.
+func (r *snapshotReader) header(kind byte, mp Mapping) {
+	if v := r.byte(); r.err == nil && v != snapshotVersion {
+		r.fail("unknown version %d", v)
+	}
+	if k := r.byte(); r.err == nil && k != kind {
+		r.fail("snapshot of kind %q, expected %q", k, kind)
+	}
+	if fp := r.uint64(); r.err == nil && fp != mappingFingerprint(mp) {
+		r.fail("taken with a different Mapping")
+	}
+}
+
+// window parses the state of a codingWindow appended by appendSnapshot.
+func (r *snapshotReader) window() windowSnapshot {
+	var s windowSnapshot
+	next := r.uvarint()
+	if next >= windowNever {
+		r.fail("coded symbol index %d out of range", next)
+	}
+	s.nextIdx = int(next)
+	n := r.length(9)
+	s.symbols = make([]HashType, n)
+	s.prngs = make([]HashType, n)
+	s.keys = make([]uint32, n)
+	for i := 0; i < n; i++ {
+		s.symbols[i] = r.uint32()
+		s.prngs[i] = r.uint32()
+		key := r.uvarint()
+		if r.err == nil && (key > windowNever || key < next) {
+			r.fail("source symbol mapped to coded symbol %d at %d", key, next)
+		}
+		s.keys[i] = uint32(key)
+	}
+	return s
+}
+
+// end checks that the whole snapshot has been read.
+func (r *snapshotReader) end() error {
+	if r.err == nil && len(r.b) != 0 {
+		r.fail("%d trailing bytes", len(r.b))
+	}
+	return r.err
+}
+
+// Snapshot returns the state of e, from which Restore rebuilds an Encoder
+// that continues the same sequence of coded symbols, e.g., after a process
+// restart. The Mapping and the Scheduler are not part of the snapshot.
+func (e *Encoder) Snapshot() []byte {
+	w := (*codingWindow)(e)
+	b := appendSnapshotHeader(nil, snapshotEncoder, w.mapping)
+	return w.appendSnapshot(b)
+}
+
+// Restore replaces the state of e with a snapshot returned by
+// Encoder.Snapshot. e must be set up with the same Mapping as the Encoder the
+// snapshot was taken from, and keeps its Scheduler. If the snapshot is
+// invalid, Restore returns an error wrapping ErrInvalidSnapshot, and leaves e
+// unchanged.
+func (e *Encoder) Restore(b []byte) error {
+	w := (*codingWindow)(e)
+	r := snapshotReader{b: b}
+	r.header(snapshotEncoder, w.mapping)
+	s := r.window()
+	if err := r.end(); err != nil {
+		return err
+	}
+	w.restore(s)
+	return nil
+}
+
+// decoderSnapshot is the parsed state of a Decoder.
+type decoderSnapshot struct {
+	window, local, remote windowSnapshot
+	cs                    []CodedSymbol
+	decodable             []int
+	decoded               int
+	lost                  []int
+	streak                int
+	checksum              uint64
+	hasChecksum           bool
+}
+
+// appendSnapshot appends the state of d to b, after the header.
+func (d *Decoder) appendSnapshot(b []byte) []byte {
+	b = d.window.appendSnapshot(b)
+	b = d.local.appendSnapshot(b)
+	b = d.remote.appendSnapshot(b)
+	b = binary.AppendUvarint(b, uint64(len(d.cs)))
+	for _, c := range d.cs {
+		b = appendCodedSymbol(b, c)
+	}
+	b = binary.AppendUvarint(b, uint64(d.decoded))
+	b = binary.AppendUvarint(b, uint64(len(d.decodable)))
+	for _, i := range d.decodable {
+		b = binary.AppendUvarint(b, uint64(i))
+	}
+	// lost coded symbols, as ascending deltas
+	b = binary.AppendUvarint(b, uint64(d.nlost))
+	prev := 0
+	for i, l := range d.lost {
+		if l {
+			b = binary.AppendUvarint(b, uint64(i-prev))
+			prev = i
+		}
+	}
+	b = binary.AppendUvarint(b, uint64(d.streak))
+	if d.hasChecksum {
+		b = append(b, 1)
+		b = binary.LittleEndian.AppendUint64(b, d.checksum)
+	} else {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// decoder parses the state of a Decoder appended by appendSnapshot.
+func (r *snapshotReader) decoder() decoderSnapshot {
+	var s decoderSnapshot
+	s.window = r.window()
+	s.local = r.window()
+	s.remote = r.window()
+	n := r.length(int(HashTypeSize) + 1)
+	s.cs = make([]CodedSymbol, n)
+	for i := range s.cs {
+		s.cs[i].Hash = r.uint32()
+		s.cs[i].Count = r.varint()
+	}
+	for _, w := range []windowSnapshot{s.window, s.local, s.remote} {
+		if r.err == nil && w.nextIdx != n {
+			r.fail("window at coded symbol %d of %d", w.nextIdx, n)
+		}
+	}
+	decoded := r.uvarint()
+	if decoded > uint64(n) {
+		r.fail("%d of %d coded symbols decoded", decoded, n)
+	}
+	s.decoded = int(decoded)
+	s.decodable = make([]int, r.length(1))
+	for i := range s.decodable {
+		idx := r.uvarint()
+		if r.err == nil && idx >= uint64(n) {
+			r.fail("decodable coded symbol %d of %d", idx, n)
+		}
+		s.decodable[i] = int(idx)
+	}
+	s.lost = make([]int, r.length(1))
+	prev := uint64(0)
+	for i := range s.lost {
+		idx := prev + r.uvarint()
+		if r.err == nil && (idx >= uint64(n) || i > 0 && idx == prev) {
+			r.fail("lost coded symbol %d of %d", idx, n)
+		}
+		s.lost[i] = int(idx)
+		prev = idx
+	}
+	streak := r.uvarint()
+	if streak > uint64(n) {
+		r.fail("%d of %d coded symbols empty", streak, n)
+	}
+	s.streak = int(streak)
+	switch r.byte() {
+	case 0:
+	case 1:
+		s.checksum = r.uint64()
+		s.hasChecksum = true
+	default:
+		r.fail("malformed checksum")
+	}
+	return s
+}
+
+// restore replaces the state of d with s, keeping the Mapping and the
+// Scheduler of d.
+func (d *Decoder) restore(s decoderSnapshot) {
+	d.Reset()
+	d.window.restore(s.window)
+	d.local.restore(s.local)
+	d.remote.restore(s.remote)
+	d.cs = append(d.cs, s.cs...)
+	d.decodable = append(d.decodable, s.decodable...)
+	d.decoded = s.decoded
+	if len(s.lost) != 0 {
+		d.lost = make([]bool, len(d.cs), cap(d.cs))
+		for _, i := range s.lost {
+			d.lost[i] = true
+		}
+		d.nlost = len(s.lost)
+	}
+	d.streak = s.streak
+	d.checksum, d.hasChecksum = s.checksum, s.hasChecksum
+}
+
+// Snapshot returns the state of d, from which Restore rebuilds a Decoder that
+// continues decoding the same sequence of coded symbols, e.g., after a
+// process restart. The Mapping, the Scheduler and the number of
+// confirmations are not part of the snapshot, but the checksum set by
+// SetRemoteChecksum is.
+func (d *Decoder) Snapshot() []byte {
+	b := appendSnapshotHeader(nil, snapshotDecoder, d.window.mapping)
+	return d.appendSnapshot(b)
+}
+
+// Restore replaces the state of d with a snapshot returned by
+// Decoder.Snapshot. d must be set up with the same Mapping as the Decoder the
+// snapshot was taken from, and keeps its Scheduler and number of
+// confirmations. If the snapshot is
+// invalid, Restore returns an error wrapping ErrInvalidSnapshot, and leaves d
+// unchanged.
+func (d *Decoder) Restore(b []byte) error {
+	r := snapshotReader{b: b}
+	r.header(snapshotDecoder, d.window.mapping)
+	s := r.decoder()
+	if err := r.end(); err != nil {
+		return err
+	}
+	d.restore(s)
+	return nil
+}
+
+// Snapshot is like Decoder.Snapshot, and also includes the extra source
+// symbols of the peers.
+func (d *MultiDecoder) Snapshot() []byte {
+	b := appendSnapshotHeader(nil, snapshotMultiDecoder, d.window.mapping)
+	b = d.Decoder.appendSnapshot(b)
+	peers := make([]int, 0, len(d.extra))
+	for peer := range d.extra {
+		peers = append(peers, peer)
+	}
+	slices.Sort(peers)
+	b = binary.AppendUvarint(b, uint64(len(peers)))
+	for _, peer := range peers {
+		b = binary.AppendVarint(b, int64(peer))
+		symbols := d.extra[peer].symbols
+		b = binary.AppendUvarint(b, uint64(len(symbols)))
+		for _, t := range symbols {
+			b = binary.LittleEndian.AppendUint32(b, t)
+		}
+	}
+	return b
+}
+
+// Restore is like Decoder.Restore, for a snapshot returned by
+// MultiDecoder.Snapshot.
+func (d *MultiDecoder) Restore(b []byte) error {
+	r := snapshotReader{b: b}
+	r.header(snapshotMultiDecoder, d.window.mapping)
+	s := r.decoder()
+	extra := make(map[int][]HashType)
+	npeers := r.length(2)
+	for i := 0; i < npeers; i++ {
+		peer := int(r.varint())
+		symbols := make([]HashType, r.length(int(HashTypeSize)))
+		for j := range symbols {
+			symbols[j] = r.uint32()
+		}
+		extra[peer] = symbols
+	}
+	if err := r.end(); err != nil {
+		return err
+	}
+	d.Reset()
+	d.Decoder.restore(s)
+	for peer, symbols := range extra {
+		for _, t := range symbols {
+			d.SubtractPeerHash(peer, t)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package riblt
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestEncoderSnapshot(t *testing.T) {
+	for _, from := range []Scheduler{HeapScheduler, CalendarScheduler} {
+		for _, to := range []Scheduler{HeapScheduler, CalendarScheduler} {
+			enc, _, _ := newTestSets(1000, 1000)
+			enc.SetScheduler(from)
+			for i := 0; i < 500; i++ {
+				enc.ProduceNextCodedSymbol()
+			}
+			restored := Encoder{}
+			restored.SetScheduler(to)
+			if err := restored.Restore(enc.Snapshot()); err != nil {
+				t.Fatalf("Restore: %v", err)
+			}
+			for i := 500; i < 5000; i++ {
+				if a, b := enc.ProduceNextCodedSymbol(), restored.ProduceNextCodedSymbol(); a != b {
+					t.Fatalf("coded symbol %d is %v, %v after restoring", i, a, b)
+				}
+			}
+		}
+	}
+}
+
+func TestDecoderSnapshot(t *testing.T) {
+	enc, dec, remote := newTestSets(1000, 1000)
+	key := Key{1}
+	enc.SetKey(key)
+	dec.SetKey(key)
+	dec.SetRemoteChecksum(enc.Checksum())
+	dec.SetConfirmation(4)
+	rng := rand.New(rand.NewSource(1))
+	var restored *Decoder
+	for i := 0; i <= 700 || !dec.Decoded(); i++ {
+		c := enc.ProduceNextCodedSymbol()
+		if rng.Float64() < 0.1 {
+			continue
+		}
+		dec.AddCodedSymbolAt(i, c)
+		if restored != nil {
+			restored.AddCodedSymbolAt(i, c)
+			restored.TryDecode()
+		}
+		if i >= 700 && restored == nil {
+			// Snapshot before TryDecode, so that some coded symbols are
+			// pending in the decodable list.
+			restored = &Decoder{}
+			restored.SetKey(key)
+			restored.SetConfirmation(4)
+			if err := restored.Restore(dec.Snapshot()); err != nil {
+				t.Fatalf("Restore: %v", err)
+			}
+			restored.TryDecode()
+		}
+		dec.TryDecode()
+	}
+	checkRemote(t, dec, remote)
+	checkRemote(t, restored, remote)
+	if restored.Stats() != dec.Stats() || restored.checksum != dec.checksum {
+		t.Errorf("restored Decoder diverged: %v, expected %v", restored.Stats(), dec.Stats())
+	}
+	if !restored.Decoded() {
+		t.Errorf("restored Decoder did not decode")
+	}
+}
+
+func TestMultiDecoderSnapshot(t *testing.T) {
+	peers, dec, remote := newTestPeers(2, 100, 100)
+	s := newTestSymbol(1000000).Hash()
+	peers[1].AddHash(s)
+	dec.SubtractPeerHash(1, s)
+	restored := &MultiDecoder{}
+	if err := restored.Restore(dec.Snapshot()); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	decodeFromPeers(t, restored, peers, 0)
+	checkRemote(t, &restored.Decoder, remote)
+}
+
+func TestInvalidSnapshot(t *testing.T) {
+	enc, dec, _ := newTestSets(10, 10)
+	for i := 0; i < 5; i++ {
+		dec.AddCodedSymbol(enc.ProduceNextCodedSymbol())
+	}
+	b := dec.Snapshot()
+	want := dec.Snapshot()
+
+	check := func(name string, b []byte) {
+		t.Helper()
+		if err := dec.Restore(b); !errors.Is(err, ErrInvalidSnapshot) {
+			t.Errorf("%s: Restore returned %v", name, err)
+		}
+		if string(dec.Snapshot()) != string(want) {
+			t.Errorf("%s: failed Restore changed the Decoder", name)
+		}
+	}
+	for i := 0; i < len(b); i++ {
+		check("truncated", b[:i])
+	}
+	check("trailing bytes", append(b[:len(b):len(b)], 0))
+	check("version", append([]byte{snapshotVersion + 1}, b[1:]...))
+	check("Encoder snapshot", enc.Snapshot())
+
+	other := Decoder{}
+	other.SetKey(Key{1})
+	if err := other.Restore(b); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("restored snapshot with a different Mapping: %v", err)
+	}
+	if err := dec.Restore(b); err != nil {
+		t.Errorf("Restore: %v", err)
+	}
+}
+
+func TestMappingFingerprint(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	seen := make(map[uint64]bool)
+	for i := 0; i < 20000; i++ {
+		var k Key
+		rng.Read(k[:])
+		fp := mappingFingerprint(k)
+		if seen[fp] {
+			t.Fatalf("Key %x has the fingerprint of another", k)
+		}
+		seen[fp] = true
+		if mappingFingerprint(&k) != fp {
+			t.Fatalf("Key %x and its pointer have different fingerprints", k)
+		}
+	}
+	mappings := []Mapping{
+		nil,
+		FloatMapping{},
+		AlphaMapping{Alpha: 0.5},
+		AlphaMapping{Alpha: 1},
+		RegularMapping{Degree: 3, Cells: 100},
+		RegularMapping{Degree: 3, Cells: 200},
+	}
+	for _, mp := range mappings {
+		fp := mappingFingerprint(mp)
+		if seen[fp] {
+			t.Errorf("Mapping %#v has the fingerprint of another", mp)
+		}
+		seen[fp] = true
+	}
+}
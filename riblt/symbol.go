@@ -26,7 +26,8 @@
 // the Encoder and the Decoder, respectively. The user should program Alice to
 // stream coded symbols over a reliable transport to Bob, and program Bob to
 // decode the symbols and signal Alice to stop when successful. See the
-// example.
+// example. Serve and Sync implement this loop over any reliable connection,
+// such as a net.Conn.
 package riblt
 
 type HashType = uint32
@@ -0,0 +1,162 @@
+package riblt
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"slices"
+	"testing"
+)
+
+//go:generate go test -run TestVectors -update
+
+var update = flag.Bool("update", false, "regenerate testdata/vectors.json")
+
+const vectorsFile = "testdata/vectors.json"
+
+// vectorFile is the layout of testdata/vectors.json. It is meant to be read
+// by implementations in other languages, so it only uses plain JSON numbers,
+// which are all below 2^32 in magnitude.
+type vectorFile struct {
+	Description string   `json:"description"`
+	Vectors     []vector `json:"vectors"`
+}
+
+// vector is a test vector. The coded symbol sequence of the source symbols
+// Symbols, minus that of Subtracted, starts with CodedSymbols. If Difference
+// is not nil, decoding CodedSymbols recovers it, sorted.
+type vector struct {
+	Name         string           `json:"name"`
+	Symbols      []HashType       `json:"symbols"`
+	Subtracted   []HashType       `json:"subtracted,omitempty"`
+	CodedSymbols []vectorCodedSym `json:"coded_symbols"`
+	Difference   []HashType       `json:"difference,omitempty"`
+}
+
+type vectorCodedSym struct {
+	Hash  HashType `json:"hash"`
+	Count int64    `json:"count"`
+}
+
+// vectorSymbols returns n pseudorandom source symbols generated with
+// SplitMix64 from seed, so that the vectors are reproducible.
+func vectorSymbols(seed uint64, n int) []HashType {
+	s := make([]HashType, n)
+	for i := range s {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		z ^= z >> 31
+		s[i] = HashType(z)
+	}
+	return s
+}
+
+// newVector fills in the outputs of a vector from its inputs. It computes
+// the coded symbols with an Encoder, a Sketch and random access, and panics
+// if they disagree.
+func newVector(name string, symbols, subtracted []HashType, n int, decode bool) vector {
+	enc := Encoder{}
+	sub := Encoder{}
+	sketch := make(Sketch, n)
+	for _, x := range symbols {
+		enc.AddHash(x)
+		sketch.AddSymbol(x)
+	}
+	for _, x := range subtracted {
+		sub.AddHash(x)
+		sketch.RemoveSymbol(x)
+	}
+	random := enc.ProduceCodedSymbols(0, n)
+	v := vector{
+		Name:       name,
+		Symbols:    symbols,
+		Subtracted: subtracted,
+	}
+	for i := 0; i < n; i++ {
+		c := enc.ProduceNextCodedSymbol()
+		if c != random[i] {
+			panic("Encoder and ProduceCodedSymbols disagree on test vector " + name)
+		}
+		s := sub.ProduceNextCodedSymbol()
+		c.Hash ^= s.Hash
+		c.Count -= s.Count
+		if c != sketch[i] {
+			panic("Encoder and Sketch disagree on test vector " + name)
+		}
+		v.CodedSymbols = append(v.CodedSymbols, vectorCodedSym{c.Hash, c.Count})
+	}
+	if decode {
+		fwd, rev, succ := sketch.Decode()
+		if !succ || len(rev) != 0 {
+			panic("failed to decode test vector " + name)
+		}
+		slices.Sort(fwd)
+		v.Difference = fwd
+	}
+	return v
+}
+
+// generateVectors returns the contents of testdata/vectors.json.
+func generateVectors() []byte {
+	random := vectorSymbols(1, 1000)
+	f := vectorFile{
+		Description: "Prefixes of Rateless IBLT coded symbol sequences with " +
+			"the built-in mapping. coded_symbols is the prefix for the " +
+			"symbols minus the prefix for the subtracted symbols: hash is the " +
+			"XOR of the source symbols mapped to a coded symbol, and count " +
+			"is their number, counting subtracted source symbols as -1. If " +
+			"present, difference lists the symbols minus the subtracted " +
+			"symbols, in ascending order, which are decodable from the prefix.",
+		Vectors: []vector{
+			newVector("empty", []HashType{}, nil, 8, false),
+			newVector("one", []HashType{1}, nil, 16, false),
+			newVector("small", []HashType{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, nil, 32, false),
+			newVector("large", []HashType{0x7fffffff, 0x80000000, 0xfffffffe, 0xffffffff}, nil, 16, false),
+			newVector("random-100", random[:100], nil, 150, false),
+			newVector("random-1000", random, nil, 100, false),
+			newVector("subtract-10", random[:100], random[10:100], 30, true),
+			newVector("subtract-100", random, random[100:], 200, true),
+		},
+	}
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return append(b, '\n')
+}
+
+func TestVectors(t *testing.T) {
+	got := generateVectors()
+	if *update {
+		if err := os.WriteFile(vectorsFile, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(vectorsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, want) {
+		return
+	}
+	// Report the vectors that differ.
+	var gotf, wantf vectorFile
+	if err := json.Unmarshal(want, &wantf); err != nil {
+		t.Fatalf("parsing %s: %v", vectorsFile, err)
+	}
+	json.Unmarshal(got, &gotf)
+	if len(gotf.Vectors) != len(wantf.Vectors) {
+		t.Fatalf("generated %d vectors, %s has %d", len(gotf.Vectors), vectorsFile, len(wantf.Vectors))
+	}
+	for i := range gotf.Vectors {
+		g, _ := json.Marshal(gotf.Vectors[i])
+		w, _ := json.Marshal(wantf.Vectors[i])
+		if !bytes.Equal(g, w) {
+			t.Errorf("vector %s does not match %s", wantf.Vectors[i].Name, vectorsFile)
+		}
+	}
+	t.Errorf("%s does not match; run go generate if the change is intended", vectorsFile)
+}